@@ -16,13 +16,13 @@ import (
 
 	// APIs - start
 
-	_ "github.com/egfanboy/mediapire-manager/internal/changeset"
+	"github.com/egfanboy/mediapire-manager/internal/changeset"
 	_ "github.com/egfanboy/mediapire-manager/internal/health"
 	"github.com/egfanboy/mediapire-manager/internal/media"
 	"github.com/egfanboy/mediapire-manager/internal/node"
 	_ "github.com/egfanboy/mediapire-manager/internal/playback"
 	_ "github.com/egfanboy/mediapire-manager/internal/settings"
-	_ "github.com/egfanboy/mediapire-manager/internal/transfer"
+	"github.com/egfanboy/mediapire-manager/internal/transfer"
 
 	// APIs - end
 
@@ -100,6 +100,7 @@ func main() {
 	}
 
 	websocket.RegisterWebSocketHandler(mainRouter, mediaManager.Config.Websocket.AllowedOrigins)
+	transfer.RegisterDownloadHandler(mainRouter)
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf("0.0.0.0:%d", mediaManager.Config.Port),
@@ -153,6 +154,33 @@ func main() {
 		log.Error().Err(err).Msg("failed to sync media from all media host nodes")
 	}
 
+	reaperCtx, stopReaper := context.WithCancel(ctx)
+	addCleanupFunc(stopReaper)
+
+	err = changeset.StartReaper(reaperCtx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to start changeset reaper")
+		os.Exit(1)
+	}
+
+	schedulerCtx, stopScheduler := context.WithCancel(ctx)
+	addCleanupFunc(stopScheduler)
+
+	err = changeset.StartScheduler(schedulerCtx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to start changeset scheduler")
+		os.Exit(1)
+	}
+
+	sweeperCtx, stopSweeper := context.WithCancel(ctx)
+	addCleanupFunc(stopSweeper)
+
+	err = transfer.StartSweeper(sweeperCtx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to start transfer sweeper")
+		os.Exit(1)
+	}
+
 	log.Info().Msg("Mediapire Manager running")
 
 	<-c
@@ -1,6 +1,6 @@
 module github.com/egfanboy/mediapire-manager
 
-go 1.18
+go 1.20
 
 require (
 	github.com/egfanboy/mediapire-common v0.0.0-20250903231047-1ebeb5a7595e
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 
+	"github.com/egfanboy/mediapire-manager/pkg/types"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
@@ -27,10 +28,51 @@ type config struct {
 		Port     int    `yaml:"port"`
 		Address  string `yaml:"address"`
 	} `yaml:"rabbit"`
+	Changesets struct {
+		// number of media items rewritten at the same time
+		Concurrency int `yaml:"concurrency"`
+		// upper bound of the size of a single update message sent to a media host, items that do not fit in a message on their own fail.
+		// It should not exceed the max message size of the broker
+		MaxMessageSizeMB int `yaml:"maxMessageSizeMB"`
+		// changesets that are not complete after this duration are failed
+		ExpiryMinutes int `yaml:"expiryMinutes"`
+		// how often expired changesets are looked for
+		ReaperIntervalSeconds int `yaml:"reaperIntervalSeconds"`
+		// how often scheduled changesets that are due are looked for
+		SchedulerIntervalSeconds int `yaml:"schedulerIntervalSeconds"`
+	} `yaml:"changesets"`
+	Transfers struct {
+		// layout of the files in transfer archives, flat, node or tags
+		Layout string `yaml:"layout"`
+		// how often expired transfers and their archives are cleaned up
+		SweeperIntervalSeconds int `yaml:"sweeperIntervalSeconds"`
+		// upper bound of the size of the message sending an archive to a media host target, larger archives fail.
+		// It should not exceed the max message size of the broker
+		MaxMessageSizeMB int `yaml:"maxMessageSizeMB"`
+	} `yaml:"transfers"`
+	Metadata struct {
+		// musicbrainz or fixture
+		Provider    string `yaml:"provider"`
+		BaseURL     string `yaml:"baseUrl"`
+		FixturePath string `yaml:"fixturePath"`
+	} `yaml:"metadata"`
 	MongoURI     string `yaml:"mongoConnectionURI"`
 	DownloadPath string `yaml:"-"`
 }
 
+const (
+	defaultChangesetConcurrency       = 4
+	defaultChangesetMaxMessageSizeMB  = 128 // the default max message size of RabbitMQ
+	defaultChangesetExpiryMinutes     = 60
+	defaultChangesetReaperInterval    = 60
+	defaultChangesetSchedulerInterval = 30
+	defaultTransferLayout             = types.TransferLayoutFlat
+	defaultTransferSweeperInterval    = 300
+	defaultTransferMaxMessageSizeMB   = 128
+	defaultMetadataProvider           = "musicbrainz"
+	defaultMetadataBaseURL            = "https://musicbrainz.org"
+)
+
 func getDownloadPath() (string, error) {
 	basePath, err := os.UserHomeDir()
 	if err != nil {
@@ -73,6 +115,56 @@ func parseConfig() (config, error) {
 		os.Exit(1)
 	}
 
+	if conf.Changesets.Concurrency <= 0 {
+		conf.Changesets.Concurrency = defaultChangesetConcurrency
+	}
+
+	if conf.Changesets.MaxMessageSizeMB <= 0 {
+		conf.Changesets.MaxMessageSizeMB = defaultChangesetMaxMessageSizeMB
+	}
+
+	if conf.Changesets.ExpiryMinutes <= 0 {
+		conf.Changesets.ExpiryMinutes = defaultChangesetExpiryMinutes
+	}
+
+	if conf.Changesets.ReaperIntervalSeconds <= 0 {
+		conf.Changesets.ReaperIntervalSeconds = defaultChangesetReaperInterval
+	}
+
+	if conf.Changesets.SchedulerIntervalSeconds <= 0 {
+		conf.Changesets.SchedulerIntervalSeconds = defaultChangesetSchedulerInterval
+	}
+
+	if conf.Transfers.Layout == "" {
+		conf.Transfers.Layout = defaultTransferLayout
+	}
+
+	switch conf.Transfers.Layout {
+	case types.TransferLayoutFlat, types.TransferLayoutNode, types.TransferLayoutTags:
+	default:
+		log.Error().Msgf(
+			"Unknown transfers.layout %q in the config file, expected %s, %s or %s",
+			conf.Transfers.Layout, types.TransferLayoutFlat, types.TransferLayoutNode, types.TransferLayoutTags,
+		)
+		os.Exit(1)
+	}
+
+	if conf.Transfers.SweeperIntervalSeconds <= 0 {
+		conf.Transfers.SweeperIntervalSeconds = defaultTransferSweeperInterval
+	}
+
+	if conf.Transfers.MaxMessageSizeMB <= 0 {
+		conf.Transfers.MaxMessageSizeMB = defaultTransferMaxMessageSizeMB
+	}
+
+	if conf.Metadata.Provider == "" {
+		conf.Metadata.Provider = defaultMetadataProvider
+	}
+
+	if conf.Metadata.BaseURL == "" {
+		conf.Metadata.BaseURL = defaultMetadataBaseURL
+	}
+
 	dlPath, err := getDownloadPath()
 	if err != nil {
 		return conf, err
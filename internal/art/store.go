@@ -0,0 +1,170 @@
+package art
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	mediapireMongo "github.com/egfanboy/mediapire-manager/internal/mongo"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	bucketName = "art"
+	// blobs are saved before the changeset referencing them is saved, do not collect recently used blobs
+	gcGracePeriod = time.Hour
+	// last time the blob was saved, stored in the metadata of the GridFS file
+	keyLastUsed = "metadata.lastUsed"
+)
+
+var ErrArtNotFound = errors.New("art not found")
+
+type fileDocument struct {
+	Id string `bson:"_id"`
+}
+
+// Store saves art blobs in GridFS, blobs are addressed by the sha256 of their content so identical art is only stored once
+type Store struct {
+}
+
+func (s *Store) getBucket() (*gridfs.Bucket, error) {
+	return mediapireMongo.NewBucket(bucketName)
+}
+
+// Save stores the content if it is not already stored and returns its blob id.
+// Saving content that is already stored marks it as used so it is not collected before the changeset referencing it is saved
+func (s *Store) Save(ctx context.Context, content []byte) (string, error) {
+	hash := sha256.Sum256(content)
+	id := hex.EncodeToString(hash[:])
+
+	bucket, err := s.getBucket()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	result, err := bucket.GetFilesCollection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{keyLastUsed: now}})
+	if err != nil {
+		return "", err
+	}
+
+	if result.MatchedCount > 0 {
+		return id, nil
+	}
+
+	err = bucket.UploadFromStreamWithID(id, id, bytes.NewReader(content), options.GridFSUpload().SetMetadata(bson.M{"lastUsed": now}))
+	// two identical uploads can race, the blob being stored is what matters
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s *Store) Exists(ctx context.Context, id string) (bool, error) {
+	bucket, err := s.getBucket()
+	if err != nil {
+		return false, err
+	}
+
+	count, err := bucket.GetFilesCollection().CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (s *Store) Get(ctx context.Context, id string) ([]byte, error) {
+	bucket, err := s.getBucket()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	_, err = bucket.DownloadToStream(id, &buf)
+	if err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrArtNotFound, id)
+		}
+
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// WriteToFile writes the blob to a file in dir since ffmpeg needs the art to be a file, returns the path of the file
+func (s *Store) WriteToFile(ctx context.Context, id string, dir string) (string, error) {
+	content, err := s.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	filePath := path.Join(dir, fmt.Sprintf("art-%s", id))
+
+	err = os.WriteFile(filePath, content, 0666)
+	if err != nil {
+		return "", err
+	}
+
+	return filePath, nil
+}
+
+// CollectGarbage deletes the blobs which are not referenced, referenced is keyed by blob id
+func (s *Store) CollectGarbage(ctx context.Context, referenced map[string]bool) error {
+	bucket, err := s.getBucket()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-gcGracePeriod)
+
+	cur, err := bucket.GetFilesCollection().Find(ctx, bson.M{"$or": bson.A{
+		bson.M{keyLastUsed: bson.M{"$lt": cutoff}},
+		// blobs saved before their last use was tracked
+		bson.M{keyLastUsed: bson.M{"$exists": false}, "uploadDate": bson.M{"$lt": cutoff}},
+	}})
+	if err != nil {
+		return err
+	}
+
+	var files []fileDocument
+
+	err = cur.All(ctx, &files)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if referenced[file.Id] {
+			continue
+		}
+
+		err = bucket.DeleteContext(ctx, file.Id)
+		if err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+			log.Err(err).Msgf("failed to delete unreferenced art %s", file.Id)
+			continue
+		}
+
+		log.Debug().Msgf("Deleted unreferenced art %s", file.Id)
+	}
+
+	return nil
+}
+
+func NewStore() (*Store, error) {
+	return &Store{}, nil
+}
@@ -3,6 +3,7 @@ package changeset
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/egfanboy/mediapire-common/messaging"
 	"github.com/egfanboy/mediapire-manager/internal/media"
@@ -31,64 +32,58 @@ func (u updatedMessageHandler) HandleMessage(ctx context.Context, msg amqp091.De
 		return
 	}
 
-	changesetService, err := newChangesetService(ctx)
+	repo, err := newChangesetRepository(ctx)
 	if err != nil {
 		log.Err(err).Msgf("cannot process media updated message for changeset %s", updateMsg.ChangesetId)
 		return
 	}
 
-	changeset, err := changesetService.GetChangesetById(ctx, changesetObjectId)
-	if err != nil {
-		log.Err(err).Msgf("failed to get changeset %s", updateMsg.ChangesetId)
-		return
-	}
+	// late acknowledgements of a node that already failed should not complete the changeset twice
+	var wasDone bool
 
-	repo, err := newChangesetRepository(ctx)
-	if err != nil {
-		log.Err(err).Msgf("cannot process media updated message for changeset %s", updateMsg.ChangesetId)
-		return
-	}
+	changeset, err := repo.Update(ctx, changesetObjectId, func(changeset *Changeset) error {
+		wasDone = changeset.IsDone()
 
-	if !updateMsg.Success {
-		log.Error().Msgf("Error occured in the updating of media for changeset %s", updateMsg.ChangesetId)
+		if !updateMsg.Success {
+			log.Error().Msgf("Error occured in the updating of media for changeset %s on node %s", updateMsg.ChangesetId, updateMsg.NodeId)
 
-		changeset.Status = StatusFailed
-		if updateMsg.FailureReason != nil {
-			changeset.FailureReason = *updateMsg.FailureReason
-			log.Error().Msgf("failure reason for changeset %s: %s", updateMsg.ChangesetId, *updateMsg.FailureReason)
-		}
+			failureReason := fmt.Sprintf("node %s failed to apply the changeset", updateMsg.NodeId)
+			if updateMsg.FailureReason != nil {
+				failureReason = *updateMsg.FailureReason
+				log.Error().Msgf("failure reason for changeset %s: %s", updateMsg.ChangesetId, *updateMsg.FailureReason)
+			}
 
-		err = repo.Save(ctx, changeset)
-		if err != nil {
-			log.Err(err).Msgf("failed to update changeset %s", updateMsg.ChangesetId)
-			return
+			changeset.FailChunk(updateMsg.NodeId, failureReason)
+		} else {
+			// nodes report a single result per message, the items of the message are applied
+			changeset.AcknowledgeChunk(updateMsg.NodeId)
 		}
 
-	} else {
-		changeset.Outputs[updateMsg.NodeId] = true
+		changeset.ResolveStatus()
 
-		if changeset.IsDone() {
-			changeset.Status = StatusComplete
-		}
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msgf("failed to update changeset %s", updateMsg.ChangesetId)
+		return
+	}
 
-		err = repo.Save(ctx, changeset)
-		if err != nil {
-			log.Err(err).Msgf("failed to update changeset %s to %s", updateMsg.ChangesetId, StatusComplete)
-			return
-		}
+	if wasDone || !changeset.IsDone() {
+		log.Info().Msg("Handled media updated message")
+		return
+	}
 
-		if changeset.IsDone() {
-			syncService, err := media.NewMediaSyncService(ctx)
-			if err != nil {
-				log.Err(err).Msgf("failed to initialize media sync service for changeset %s", updateMsg.ChangesetId)
-				return
-			}
+	syncService, err := media.NewMediaSyncService(ctx)
+	if err != nil {
+		log.Err(err).Msgf("failed to initialize media sync service for changeset %s", updateMsg.ChangesetId)
+		return
+	}
 
-			err = syncService.SyncNodeMedia(ctx, updateMsg.NodeId)
-			if err != nil {
-				log.Err(err).Msgf("failed to refresh media for node %s after changeset %s", updateMsg.NodeId, updateMsg.ChangesetId)
-				return
-			}
+	// even a failed node may have applied part of the changeset, refresh every node it affects
+	for nodeId := range changeset.Inputs {
+		err = syncService.SyncNodeMedia(ctx, nodeId)
+		if err != nil {
+			log.Err(err).Msgf("failed to refresh media for node %s after changeset %s", nodeId, updateMsg.ChangesetId)
 		}
 	}
 
@@ -0,0 +1,48 @@
+package changeset
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errChangesetCancelled = errors.New("changeset was cancelled")
+	// returned when the changeset stops being active while it is delegated
+	errChangesetStopped = errors.New("changeset is no longer active")
+)
+
+var (
+	runningMu sync.Mutex
+	// cancels the context of every changeset being delegated, keyed by changeset id
+	running = make(map[primitive.ObjectID]context.CancelFunc)
+)
+
+// startRun returns the context to delegate the changeset with, done must be called once the changeset was delegated
+func startRun(changesetId primitive.ObjectID) (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	runningMu.Lock()
+	running[changesetId] = cancel
+	runningMu.Unlock()
+
+	return ctx, func() {
+		runningMu.Lock()
+		delete(running, changesetId)
+		runningMu.Unlock()
+
+		cancel()
+	}
+}
+
+// cancelRun stops the delegation of the changeset if it is still running
+func cancelRun(changesetId primitive.ObjectID) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+
+	if cancel, ok := running[changesetId]; ok {
+		cancel()
+	}
+}
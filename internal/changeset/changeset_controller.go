@@ -5,18 +5,32 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 
+	"github.com/egfanboy/mediapire-common/exceptions"
 	"github.com/egfanboy/mediapire-common/router"
 	"github.com/egfanboy/mediapire-manager/internal/app"
+	"github.com/egfanboy/mediapire-manager/internal/media"
 	"github.com/egfanboy/mediapire-manager/pkg/types"
+	"github.com/egfanboy/mediapire-manager/pkg/types/pagination"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const (
-	basePath         = "/changesets"
-	paramChangesetId = "changesetId"
+	basePath                = "/changesets"
+	paramChangesetId        = "changesetId"
+	queryParamDryRun        = "dryRun"
+	queryParamStatus        = "status"
+	queryParamType          = "type"
+	queryParamNodeId        = "nodeId"
+	queryParamCreatedAfter  = "createdAfter"
+	queryParamCreatedBefore = "createdBefore"
+
+	contentTypeJson = "application/json"
+	formFieldArt    = "art"
 )
 
 type changesetController struct {
@@ -37,21 +51,60 @@ func (c changesetController) GetChangesets() router.RouteBuilder {
 		SetMethod(http.MethodOptions, http.MethodGet).
 		SetPath(basePath).
 		SetReturnCode(http.StatusOK).
+		AddQueryParam(router.QueryParam{Name: queryParamStatus, Required: false}).
+		AddQueryParam(router.QueryParam{Name: queryParamType, Required: false}).
+		AddQueryParam(router.QueryParam{Name: queryParamNodeId, Required: false}).
+		AddQueryParam(router.QueryParam{Name: queryParamCreatedAfter, Required: false}).
+		AddQueryParam(router.QueryParam{Name: queryParamCreatedBefore, Required: false}).
+		AddQueryParam(pagination.PageQueryParam).
+		AddQueryParam(pagination.LimitQueryParam).
 		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
-			r, err := c.service.GetChangesets(request.Context())
+			filter, err := newChangesetFilter(p)
 			if err != nil {
 				return nil, err
 			}
 
-			result := make([]types.ChangesetItem, len(r))
-			for i, v := range r {
-				result[i] = v.ToApiResponse()
+			var paginationParams *pagination.ApiPaginationParams
+			if _, ok := p.Params[pagination.PageQueryParam.Name]; ok {
+				pagination, err := pagination.NewApiPaginationParams(p)
+				if err != nil {
+					return nil, err
+				}
+
+				paginationParams = &pagination
 			}
 
-			return result, nil
+			return c.service.GetChangesets(request.Context(), filter, paginationParams)
 		})
 }
 
+func newChangesetFilter(p router.RouteParams) (filter changesetFilter, err error) {
+	if statusQuery, ok := p.Params[queryParamStatus]; ok {
+		for _, status := range strings.Split(statusQuery, ",") {
+			filter.Statuses = append(filter.Statuses, changesetStatus(status))
+		}
+	}
+
+	if typeQuery, ok := p.Params[queryParamType]; ok {
+		for _, t := range strings.Split(typeQuery, ",") {
+			filter.Types = append(filter.Types, changesetType(t))
+		}
+	}
+
+	if nodeId, ok := p.Params[queryParamNodeId]; ok {
+		filter.NodeId = &nodeId
+	}
+
+	filter.CreatedAfter, err = pagination.ParseTimeParam(p, queryParamCreatedAfter)
+	if err != nil {
+		return
+	}
+
+	filter.CreatedBefore, err = pagination.ParseTimeParam(p, queryParamCreatedBefore)
+
+	return
+}
+
 func (c changesetController) GetChangesetId() router.RouteBuilder {
 	return router.NewV1RouteBuilder().
 		SetMethod(http.MethodOptions, http.MethodGet).
@@ -78,75 +131,306 @@ func (c changesetController) GetChangesetId() router.RouteBuilder {
 
 }
 
+// parses the JSON changeset request where the art is referenced or sent as base64 content
+func (c changesetController) parseJsonCreateRequest(request *http.Request) (types.ChangesetCreateRequest, error) {
+	var body types.ChangesetJsonCreateRequest
+
+	err := json.NewDecoder(request.Body).Decode(&body)
+	if err != nil {
+		return types.ChangesetCreateRequest{}, exceptions.NewBadRequestException(err)
+	}
+
+	return c.service.ResolveJsonRequest(request.Context(), body)
+}
+
+// parses the multipart or JSON changeset request, storing any art in the art store.
+// returns the request with the art replaced by the id of the stored blobs
+func (c changesetController) parseCreateRequest(request *http.Request) (body types.ChangesetCreateRequest, err error) {
+	if contentType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type")); contentType == contentTypeJson {
+		return c.parseJsonCreateRequest(request)
+	}
+
+	err = request.ParseMultipartForm(32 << 20)
+	if err != nil {
+		return
+	}
+
+	jsonData := request.FormValue("data")
+
+	err = json.Unmarshal([]byte(jsonData), &body)
+	if err != nil {
+		return
+	}
+
+	blobIds := make(map[string]string)
+
+	transformedItems := make([]types.Changeset, len(body.Changes))
+	// Loop over changes and for any change to the art parse the file from the request form
+	for i, item := range body.Changes {
+		if item.Change.Art != "" {
+			// already stored, just overrite the value to the blob id
+			if blobId, ok := blobIds[item.Change.Art]; ok {
+				item.Change.Art = blobId
+				transformedItems[i] = item
+				continue
+			}
+
+			file, _, fileErr := request.FormFile(item.Change.Art)
+			if fileErr != nil {
+				err = fileErr
+				return
+			}
+
+			defer file.Close()
+
+			fileContent, readErr := io.ReadAll(file)
+			if readErr != nil {
+				err = readErr
+				return
+			}
+
+			blobId, saveErr := c.service.SaveArt(request.Context(), fileContent)
+			if saveErr != nil {
+				err = saveErr
+				return
+			}
+
+			blobIds[item.Change.Art] = blobId
+			item.Change.Art = blobId
+
+			transformedItems[i] = item
+
+		} else {
+			// nothing to change
+			transformedItems[i] = item
+		}
+	}
+
+	body.Changes = transformedItems
+
+	return
+}
+
 func (c changesetController) CreateChangeset() router.RouteBuilder {
 	return router.NewV1RouteBuilder().
 		SetMethod(http.MethodOptions, http.MethodPost).
 		SetPath(basePath).
 		SetReturnCode(http.StatusAccepted).
+		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
+			body, err := c.parseCreateRequest(request)
+			if err != nil {
+				return nil, err
+			}
+
+			r, err := c.service.CreateChangeset(request.Context(), body)
+			if err != nil {
+				return nil, err
+			}
+
+			return r.ToApiResponse(), nil
+		})
+}
+
+// create a second route that will only match /changesets?dryRun=true, it needs to go before CreateChangeset
+func (c changesetController) PreviewChangeset() router.RouteBuilder {
+	return router.NewV1RouteBuilder().
+		SetMethod(http.MethodOptions, http.MethodPost).
+		SetPath(basePath).
+		SetReturnCode(http.StatusOK).
+		AddQueryParam(router.QueryParam{Name: queryParamDryRun, Required: true}).
+		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
+			dryRun, err := strconv.ParseBool(p.Params[queryParamDryRun])
+			if err != nil {
+				return nil, exceptions.NewBadRequestException(fmt.Errorf("invalid %s query param: %w", queryParamDryRun, err))
+			}
+
+			// changesets are created without the query param
+			if !dryRun {
+				return nil, exceptions.NewBadRequestException(fmt.Errorf("%s must be true to preview a changeset, omit it to create one", queryParamDryRun))
+			}
+
+			body, err := c.parseCreateRequest(request)
+			if err != nil {
+				return nil, err
+			}
+
+			// art stored for the preview is garbage collected since no changeset references it
+			return c.service.PreviewChangeset(request.Context(), body)
+		})
+}
+
+// uploads art that JSON changeset requests can then reference by id
+func (c changesetController) UploadArt() router.RouteBuilder {
+	return router.NewV1RouteBuilder().
+		SetMethod(http.MethodOptions, http.MethodPost).
+		SetPath(basePath + "/art").
+		SetReturnCode(http.StatusCreated).
 		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
 			err := request.ParseMultipartForm(32 << 20)
+			if err != nil {
+				return nil, exceptions.NewBadRequestException(err)
+			}
+
+			file, _, err := request.FormFile(formFieldArt)
+			if err != nil {
+				return nil, exceptions.NewBadRequestException(fmt.Errorf("art must be uploaded in the %s form field: %w", formFieldArt, err))
+			}
+
+			defer file.Close()
+
+			content, err := io.ReadAll(file)
 			if err != nil {
 				return nil, err
 			}
 
-			jsonData := request.FormValue("data")
+			blobId, err := c.service.SaveArt(request.Context(), content)
+			if err != nil {
+				return nil, err
+			}
 
-			var body types.ChangesetCreateRequest
+			return types.ArtUploadResponse{Id: blobId}, nil
+		})
+}
 
-			err = json.Unmarshal([]byte(jsonData), &body)
+func (c changesetController) SuggestTags() router.RouteBuilder {
+	return router.NewV1RouteBuilder().
+		SetMethod(http.MethodOptions, http.MethodPost).
+		SetPath(basePath + "/suggestions").
+		SetReturnCode(http.StatusOK).
+		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
+			var body types.TagSuggestionRequest
+			err := p.PopulateBody(&body)
 			if err != nil {
 				return nil, err
 			}
 
-			files := make(map[string]string)
+			return c.service.SuggestTags(request.Context(), body)
+		})
+}
 
-			transformedItems := make([]types.Changeset, len(body.Changes))
-			// Loop over changes and for any change to the art parse the file from the request form
-			for i, item := range body.Changes {
-				if item.Change.Art != "" {
-					// already exists, just overrite the value to the path
-					if tempFilePath, ok := files[item.Change.Art]; ok {
-						item.Change.Art = tempFilePath
-						transformedItems[i] = item
-						continue
-					}
+func (c changesetController) RevertChangeset() router.RouteBuilder {
+	return router.NewV1RouteBuilder().
+		SetMethod(http.MethodOptions, http.MethodPost).
+		SetPath(fmt.Sprintf("%s/{%s}/revert", basePath, paramChangesetId)).
+		SetReturnCode(http.StatusAccepted).
+		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
+			changesetId, ok := p.Params[paramChangesetId]
+			if !ok {
+				return nil, fmt.Errorf("%s not found in API path", paramChangesetId)
+			}
+
+			changesetObjectId, err := primitive.ObjectIDFromHex(changesetId)
+			if err != nil {
+				return nil, err
+			}
+
+			r, err := c.service.RevertChangeset(request.Context(), changesetObjectId)
+			if err != nil {
+				return nil, err
+			}
 
-					file, _, err := request.FormFile(item.Change.Art)
-					if err != nil {
-						return nil, err
-					}
+			return r.ToApiResponse(), nil
+		})
+}
 
-					defer file.Close()
+func (c changesetController) UpdateDraft() router.RouteBuilder {
+	return router.NewV1RouteBuilder().
+		SetMethod(http.MethodOptions, http.MethodPatch).
+		SetPath(fmt.Sprintf("%s/{%s}", basePath, paramChangesetId)).
+		SetReturnCode(http.StatusOK).
+		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
+			changesetId, ok := p.Params[paramChangesetId]
+			if !ok {
+				return nil, fmt.Errorf("%s not found in API path", paramChangesetId)
+			}
 
-					fileContent, err := io.ReadAll(file)
-					if err != nil {
-						return nil, err
-					}
+			changesetObjectId, err := primitive.ObjectIDFromHex(changesetId)
+			if err != nil {
+				return nil, err
+			}
 
-					temp, err := os.CreateTemp("", item.Change.Art)
-					if err != nil {
-						return nil, err
-					}
+			var body types.ChangesetPatchRequest
+			err = p.PopulateBody(&body)
+			if err != nil {
+				return nil, err
+			}
 
-					_, err = temp.Write(fileContent)
-					if err != nil {
-						return nil, err
-					}
+			r, err := c.service.UpdateDraft(request.Context(), changesetObjectId, body)
+			if err != nil {
+				return nil, err
+			}
 
-					files[item.Change.Art] = temp.Name()
-					item.Change.Art = temp.Name()
+			return r.ToApiResponse(), nil
+		})
+}
 
-					transformedItems[i] = item
+func (c changesetController) CommitChangeset() router.RouteBuilder {
+	return router.NewV1RouteBuilder().
+		SetMethod(http.MethodOptions, http.MethodPost).
+		SetPath(fmt.Sprintf("%s/{%s}/commit", basePath, paramChangesetId)).
+		SetReturnCode(http.StatusAccepted).
+		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
+			changesetId, ok := p.Params[paramChangesetId]
+			if !ok {
+				return nil, fmt.Errorf("%s not found in API path", paramChangesetId)
+			}
 
-				} else {
-					// nothing to change
-					transformedItems[i] = item
-				}
+			changesetObjectId, err := primitive.ObjectIDFromHex(changesetId)
+			if err != nil {
+				return nil, err
 			}
 
-			body.Changes = transformedItems
+			r, err := c.service.CommitChangeset(request.Context(), changesetObjectId)
+			if err != nil {
+				return nil, err
+			}
 
-			r, err := c.service.CreateChangeset(request.Context(), body)
+			return r.ToApiResponse(), nil
+		})
+}
+
+func (c changesetController) CancelChangeset() router.RouteBuilder {
+	return router.NewV1RouteBuilder().
+		SetMethod(http.MethodOptions, http.MethodPost).
+		SetPath(fmt.Sprintf("%s/{%s}/cancel", basePath, paramChangesetId)).
+		SetReturnCode(http.StatusOK).
+		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
+			changesetId, ok := p.Params[paramChangesetId]
+			if !ok {
+				return nil, fmt.Errorf("%s not found in API path", paramChangesetId)
+			}
+
+			changesetObjectId, err := primitive.ObjectIDFromHex(changesetId)
+			if err != nil {
+				return nil, err
+			}
+
+			r, err := c.service.CancelChangeset(request.Context(), changesetObjectId)
+			if err != nil {
+				return nil, err
+			}
+
+			return r.ToApiResponse(), nil
+		})
+}
+
+func (c changesetController) RetryChangeset() router.RouteBuilder {
+	return router.NewV1RouteBuilder().
+		SetMethod(http.MethodOptions, http.MethodPost).
+		SetPath(fmt.Sprintf("%s/{%s}/retry", basePath, paramChangesetId)).
+		SetReturnCode(http.StatusAccepted).
+		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
+			changesetId, ok := p.Params[paramChangesetId]
+			if !ok {
+				return nil, fmt.Errorf("%s not found in API path", paramChangesetId)
+			}
+
+			changesetObjectId, err := primitive.ObjectIDFromHex(changesetId)
+			if err != nil {
+				return nil, err
+			}
+
+			r, err := c.service.RetryChangeset(request.Context(), changesetObjectId)
 			if err != nil {
 				return nil, err
 			}
@@ -165,12 +449,31 @@ func initController() changesetController {
 		c.builders,
 		c.GetChangesets,
 		c.GetChangesetId,
+		// PreviewChangeset needs to go before CreateChangeset since gorilla mux uses whatever matches first
+		c.PreviewChangeset,
 		c.CreateChangeset,
+		c.UploadArt,
+		c.SuggestTags,
+		c.RevertChangeset,
+		c.CancelChangeset,
+		c.RetryChangeset,
+		c.UpdateDraft,
+		c.CommitChangeset,
 	)
 
 	return c
 }
 
 func init() {
-	app.GetApp().ControllerRegistry.Register(initController())
+	controller := initController()
+	app.GetApp().ControllerRegistry.Register(controller)
+
+	media.RegisterMediaDeleter(func(ctx context.Context, request types.MediaDeleteRequest) (types.ChangesetItem, error) {
+		cs, err := controller.service.DeleteMedia(ctx, request)
+		if err != nil {
+			return types.ChangesetItem{}, err
+		}
+
+		return cs.ToApiResponse(), nil
+	})
 }
@@ -1,8 +1,11 @@
 package changeset
 
 import (
+	"fmt"
+	"sort"
 	"time"
 
+	"github.com/egfanboy/mediapire-manager/internal/app"
 	"github.com/egfanboy/mediapire-manager/internal/utils"
 	"github.com/egfanboy/mediapire-manager/pkg/types"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -10,10 +13,14 @@ import (
 
 type changesetStatus string
 type changesetType string
+type itemStatus string
 
 type input struct {
-	MediaId string                 `bson:"mediaId"`
-	Change  map[string]interface{} `bson:"change"`
+	MediaId       string                 `bson:"mediaId"`
+	Change        map[string]interface{} `bson:"change"`
+	Status        itemStatus             `bson:"status"`
+	FailureReason string                 `bson:"failure_reason"`
+	UpdatedAt     *time.Time             `bson:"updated_at"`
 }
 
 const (
@@ -21,45 +28,406 @@ const (
 	StatusPending    changesetStatus = "pending"
 	StatusComplete   changesetStatus = "complete"
 	StatusFailed     changesetStatus = "failed"
+	// some nodes applied the changeset while others failed
+	StatusPartiallyFailed changesetStatus = "partially_failed"
+	StatusCancelled       changesetStatus = "cancelled"
+	// the changeset is being built and is only applied once committed
+	StatusDraft changesetStatus = "draft"
+	// the changeset is applied once its applyAt time is reached
+	StatusScheduled changesetStatus = "scheduled"
 
 	TypeUpdate changesetType = "update"
 	TypeDelete changesetType = "delete"
+
+	ItemStatusPending itemStatus = "pending"
+	// the item was rewritten by the manager but not yet applied by the node
+	ItemStatusRewritten itemStatus = "rewritten"
+	ItemStatusApplied   itemStatus = "applied"
+	ItemStatusFailed    itemStatus = "failed"
+	ItemStatusCancelled itemStatus = "cancelled"
 )
 
 type Changeset struct {
 	Id     primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	Inputs map[string][]input `json:"inputs" bson:"inputs"`
 	// tracks which input has responded
-	Outputs       map[string]bool `json:"-" bson:"outputs"`
-	Type          changesetType   `json:"type" bson:"type"`
-	Status        changesetStatus `json:"status" bson:"status"`
-	FailureReason string          `json:"failureReason" bson:"failure_reason"`
-	Expiry        *time.Time      `json:"expiry" bson:"expiry"`
+	Outputs map[string]bool `json:"-" bson:"outputs"`
+	// number of update messages sent to each node and how many of them were acknowledged
+	Chunks map[string]int `json:"-" bson:"chunks"`
+	Acks   map[string]int `json:"-" bson:"acks"`
+	// ids of the media sent in each update message to each node, in the order the messages were published
+	ChunkItems map[string][][]string `json:"-" bson:"chunk_items"`
+	// failure reason reported by each node that failed to apply the changeset
+	NodeFailures  map[string]string `json:"nodeFailures" bson:"node_failures"`
+	Type          changesetType     `json:"type" bson:"type"`
+	Status        changesetStatus   `json:"status" bson:"status"`
+	FailureReason string            `json:"failureReason" bson:"failure_reason"`
+	Expiry        *time.Time        `json:"expiry" bson:"expiry"`
+	// time at which a scheduled changeset is applied
+	ApplyAt *time.Time `json:"applyAt" bson:"apply_at,omitempty"`
+	// changeset undone by this changeset
+	RevertOf *primitive.ObjectID `json:"revertOf" bson:"revert_of,omitempty"`
+	// changeset whose failed items are retried by this changeset
+	RetryOf   *primitive.ObjectID `json:"retryOf" bson:"retry_of,omitempty"`
+	CreatedAt time.Time           `json:"createdAt" bson:"created_at"`
+	UpdatedAt time.Time           `json:"updatedAt" bson:"updated_at"`
+	// incremented on every save so that concurrent updates do not overwrite each other
+	Version int `json:"-" bson:"version"`
 }
 
 func (c *Changeset) ToApiResponse() types.ChangesetItem {
-	return types.ChangesetItem{
+	result := types.ChangesetItem{
 		Id:            c.Id.Hex(),
 		Status:        string(c.Status),
 		FailureReason: c.FailureReason,
 		Expiry:        c.Expiry,
+		ApplyAt:       c.ApplyAt,
 		Type:          string(c.Type),
+		NodeFailures:  c.NodeFailures,
+		Items:         c.getItemResults(),
+		ItemCounts:    c.getItemCounts(),
+		CreatedAt:     c.CreatedAt,
+		UpdatedAt:     c.UpdatedAt,
+	}
+
+	if c.RevertOf != nil {
+		result.RevertOf = c.RevertOf.Hex()
+	}
+
+	if c.RetryOf != nil {
+		result.RetryOf = c.RetryOf.Hex()
+	}
+
+	// changesets created before timestamps were tracked
+	if c.CreatedAt.IsZero() {
+		result.CreatedAt = c.Id.Timestamp()
+	}
+
+	return result
+}
+
+// ToApiSummary returns the changeset without the result of every item, used when listing changesets
+func (c *Changeset) ToApiSummary() types.ChangesetItem {
+	result := c.ToApiResponse()
+	result.Items = nil
+
+	return result
+}
+
+// returns the number of items of the changeset on each node
+func (c *Changeset) getItemCounts() map[string]int {
+	result := make(map[string]int)
+
+	for nodeId, items := range c.Inputs {
+		result[nodeId] = len(items)
+	}
+
+	return result
+}
+
+// returns the result of every item sorted by node and media
+func (c *Changeset) getItemResults() []types.ChangesetItemResult {
+	result := make([]types.ChangesetItemResult, 0)
+
+	for nodeId, items := range c.Inputs {
+		for _, item := range items {
+			status := item.Status
+			// changesets created before items were tracked
+			if status == "" {
+				status = ItemStatusPending
+			}
+
+			result = append(result, types.ChangesetItemResult{
+				MediaItemMapping: types.MediaItemMapping{NodeId: nodeId, MediaId: item.MediaId},
+				Status:           string(status),
+				FailureReason:    item.FailureReason,
+				UpdatedAt:        item.UpdatedAt,
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].NodeId != result[j].NodeId {
+			return result[i].NodeId < result[j].NodeId
+		}
+
+		return result[i].MediaId < result[j].MediaId
+	})
+
+	return result
+}
+
+func (c *Changeset) SetItemStatus(mapping types.MediaItemMapping, status itemStatus, failureReason string) {
+	items := c.Inputs[mapping.NodeId]
+
+	for i := range items {
+		if items[i].MediaId == mapping.MediaId {
+			now := time.Now()
+
+			items[i].Status = status
+			items[i].FailureReason = failureReason
+			items[i].UpdatedAt = &now
+		}
 	}
 }
 
-// For now there is always just one output but this should be changed if we support multiple
+// sets the status of every item of the node that was not applied, failed or cancelled yet
+func (c *Changeset) setNodeItemsStatus(nodeId string, status itemStatus, failureReason string) {
+	for _, item := range c.Inputs[nodeId] {
+		if !item.Status.isFinal() {
+			c.SetItemStatus(types.MediaItemMapping{NodeId: nodeId, MediaId: item.MediaId}, status, failureReason)
+		}
+	}
+}
+
+func (s itemStatus) isFinal() bool {
+	return s == ItemStatusApplied || s == ItemStatusFailed || s == ItemStatusCancelled
+}
+
+// a changeset is active until it reaches a final status
+func (c *Changeset) IsActive() bool {
+	return c.Status == StatusPending || c.Status == StatusInProgress
+}
+
+// returns the ids of the art referenced by the changes of the changeset
+func (c *Changeset) GetArtIds() []string {
+	result := make([]string, 0)
+
+	for _, items := range c.Inputs {
+		for _, item := range items {
+			if art, ok := item.Change["art"].(string); ok && art != "" {
+				result = append(result, art)
+			}
+		}
+	}
+
+	return result
+}
+
+// a changeset is done once every node it affects has responded
 func (c *Changeset) IsDone() bool {
-	var isDone bool
 	for _, v := range c.Outputs {
-		if v {
-			isDone = true
+		if !v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// records the failure of a node, a node that failed is considered as having responded
+func (c *Changeset) SetNodeFailed(nodeId string, failureReason string) {
+	if c.NodeFailures == nil {
+		c.NodeFailures = make(map[string]string)
+	}
+
+	c.NodeFailures[nodeId] = failureReason
+	c.Outputs[nodeId] = true
+
+	c.setNodeItemsStatus(nodeId, ItemStatusFailed, failureReason)
+}
 
-			return isDone
+// fails the changeset as a whole, items that were not applied yet are failed with the same reason
+func (c *Changeset) SetFailed(failureReason string) {
+	c.Status = StatusFailed
+	c.FailureReason = failureReason
+
+	for nodeId, items := range c.Inputs {
+		for _, item := range items {
+			if item.Status != ItemStatusApplied && item.Status != ItemStatusFailed {
+				c.SetItemStatus(types.MediaItemMapping{NodeId: nodeId, MediaId: item.MediaId}, ItemStatusFailed, failureReason)
+			}
 		}
+	}
+}
+
+// cancels the changeset, only items that were not sent to the media hosts yet are cancelled.
+// Items that were sent keep their status since the nodes may still apply them
+func (c *Changeset) Cancel() {
+	// the delete is sent to every node at once when the changeset starts
+	deleteSent := c.Type == TypeDelete && c.Status == StatusInProgress
+
+	c.Status = StatusCancelled
+	c.FailureReason = errChangesetCancelled.Error()
 
+	for nodeId, items := range c.Inputs {
+		for _, item := range items {
+			// rewritten items are published right after being recorded
+			sent := deleteSent || item.Status == ItemStatusRewritten
+
+			if !sent && item.Status != ItemStatusApplied && item.Status != ItemStatusFailed {
+				c.SetItemStatus(types.MediaItemMapping{NodeId: nodeId, MediaId: item.MediaId}, ItemStatusCancelled, "")
+			}
+		}
 	}
+}
 
-	return isDone
+// sets the final status of the changeset from the status of its items once every node has responded
+func (c *Changeset) ResolveStatus() {
+	// late responses of nodes do not change the status of a changeset that was cancelled or expired
+	if !c.IsDone() || !c.IsActive() {
+		return
+	}
+
+	failed, total := 0, 0
+	for _, items := range c.Inputs {
+		for _, item := range items {
+			total++
+
+			if item.Status == ItemStatusFailed {
+				failed++
+			}
+		}
+	}
+
+	switch failed {
+	case 0:
+		c.Status = StatusComplete
+	case total:
+		c.Status = StatusFailed
+
+		// keep the reason of a verification failure recorded while the items were rewritten
+		if c.FailureReason != "" {
+			break
+		}
+
+		c.FailureReason = "changeset failed for every item"
+
+		// a single node failing gives a better explanation than the generic reason
+		if len(c.NodeFailures) == 1 {
+			for _, reason := range c.NodeFailures {
+				c.FailureReason = reason
+			}
+		}
+	default:
+		c.Status = StatusPartiallyFailed
+
+		if c.FailureReason == "" {
+			c.FailureReason = fmt.Sprintf("changeset failed for %d of %d items", failed, total)
+		}
+	}
+}
+
+// records a response of the node to one of its update messages. Media hosts handle their messages in the order they were published
+// so the nth response is for the nth chunk. Returns the ids of the media of that chunk, nil for changesets created before they were
+// recorded, and whether the node responded to every message it was sent
+func (c *Changeset) respondChunk(nodeId string) ([]string, bool) {
+	if c.Acks == nil {
+		c.Acks = make(map[string]int)
+	}
+
+	index := c.Acks[nodeId]
+	c.Acks[nodeId]++
+
+	// changesets created before chunking was introduced sent a single message per node
+	expected, ok := c.Chunks[nodeId]
+	if !ok {
+		expected = 1
+	}
+
+	var mediaIds []string
+	if chunks := c.ChunkItems[nodeId]; index < len(chunks) {
+		mediaIds = chunks[index]
+	}
+
+	return mediaIds, c.Acks[nodeId] >= expected
+}
+
+// sets the status of the items of a chunk that were not applied, failed or cancelled yet
+func (c *Changeset) setChunkItemsStatus(nodeId string, mediaIds []string, status itemStatus, failureReason string) {
+	inChunk := make(map[string]bool)
+	for _, mediaId := range mediaIds {
+		inChunk[mediaId] = true
+	}
+
+	for _, item := range c.Inputs[nodeId] {
+		if inChunk[item.MediaId] && !item.Status.isFinal() {
+			c.SetItemStatus(types.MediaItemMapping{NodeId: nodeId, MediaId: item.MediaId}, status, failureReason)
+		}
+	}
+}
+
+// records the acknowledgement of an update message from the node, its items are applied.
+// The node is only done once every message it was sent is acknowledged
+func (c *Changeset) AcknowledgeChunk(nodeId string) {
+	// late responses of a node that already failed are ignored
+	if c.Outputs[nodeId] {
+		return
+	}
+
+	mediaIds, last := c.respondChunk(nodeId)
+	if mediaIds != nil {
+		c.setChunkItemsStatus(nodeId, mediaIds, ItemStatusApplied, "")
+	} else if last {
+		// without the items of each chunk the items are only known to be applied once every message is acknowledged
+		c.setNodeItemsStatus(nodeId, ItemStatusApplied, "")
+	}
+
+	if last {
+		c.Outputs[nodeId] = true
+	}
+}
+
+// records the failure of the node to apply one of its update messages, only the items of that message fail.
+// The node is done once it responded to every message it was sent
+func (c *Changeset) FailChunk(nodeId string, failureReason string) {
+	if c.Outputs[nodeId] {
+		return
+	}
+
+	mediaIds, last := c.respondChunk(nodeId)
+	if mediaIds == nil {
+		// without the items of each chunk the node fails as a whole
+		c.SetNodeFailed(nodeId, failureReason)
+		return
+	}
+
+	if c.NodeFailures == nil {
+		c.NodeFailures = make(map[string]string)
+	}
+
+	c.NodeFailures[nodeId] = failureReason
+	c.setChunkItemsStatus(nodeId, mediaIds, ItemStatusFailed, failureReason)
+
+	if last {
+		c.Outputs[nodeId] = true
+	}
+}
+
+// media hosts do not acknowledge deletes, the items of the node that are no longer in its media are applied.
+// The node is done once none of its items remain
+func (c *Changeset) ConfirmDeleted(nodeId string, remaining map[string]bool) {
+	done := true
+
+	for _, item := range c.Inputs[nodeId] {
+		if item.Status == ItemStatusFailed || item.Status == ItemStatusApplied {
+			continue
+		}
+
+		if remaining[item.MediaId] {
+			done = false
+			continue
+		}
+
+		c.SetItemStatus(types.MediaItemMapping{NodeId: nodeId, MediaId: item.MediaId}, ItemStatusApplied, "")
+	}
+
+	if done {
+		c.Outputs[nodeId] = true
+	}
+}
+
+// returns the ids of the media affected by the changeset grouped by node
+func (c *Changeset) GetMediaIdsByNode() map[string][]string {
+	result := make(map[string][]string)
+
+	for nodeId, changes := range c.Inputs {
+		for _, change := range changes {
+			result[nodeId] = append(result[nodeId], change.MediaId)
+		}
+	}
+
+	return result
 }
 
 func (c *Changeset) GetChanges() ([]types.Changeset, error) {
@@ -82,6 +450,97 @@ func (c *Changeset) GetChanges() ([]types.Changeset, error) {
 	return result, nil
 }
 
+// adds the item to the changeset or replaces its change if it is already part of it
+func (c *Changeset) UpsertItem(change types.Changeset) error {
+	mapChange, err := utils.ConvertStruct[types.MediaItemChange, map[string]interface{}](change.Change)
+	if err != nil {
+		return err
+	}
+
+	if c.Inputs == nil {
+		c.Inputs = make(map[string][]input)
+	}
+
+	if c.Outputs == nil {
+		c.Outputs = make(map[string]bool)
+	}
+
+	items := c.Inputs[change.NodeId]
+	for i := range items {
+		if items[i].MediaId == change.MediaId {
+			items[i].Change = mapChange
+			return nil
+		}
+	}
+
+	c.Inputs[change.NodeId] = append(items, input{MediaId: change.MediaId, Change: mapChange, Status: ItemStatusPending})
+	c.Outputs[change.NodeId] = false
+
+	return nil
+}
+
+// removes the item from the changeset, the node is removed once it has no items left
+func (c *Changeset) RemoveItem(mapping types.MediaItemMapping) {
+	items := c.Inputs[mapping.NodeId]
+
+	for i := range items {
+		if items[i].MediaId == mapping.MediaId {
+			items = append(items[:i], items[i+1:]...)
+			break
+		}
+	}
+
+	if len(items) == 0 {
+		delete(c.Inputs, mapping.NodeId)
+		delete(c.Outputs, mapping.NodeId)
+		return
+	}
+
+	c.Inputs[mapping.NodeId] = items
+}
+
+// starts the expiry of the changeset, it is set when the changeset is ready to be applied
+func (c *Changeset) setExpiry() {
+	expiry := time.Now().Add(time.Duration(app.GetApp().Config.Changesets.ExpiryMinutes) * time.Minute)
+	c.Expiry = &expiry
+}
+
+// makes the changeset ready to be applied, it is scheduled when its applyAt time is not reached yet
+func (c *Changeset) setReady() {
+	if c.ApplyAt != nil && c.ApplyAt.After(time.Now()) {
+		c.Status = StatusScheduled
+		return
+	}
+
+	c.Status = StatusPending
+	c.setExpiry()
+}
+
+// returns the changes of the items that failed or were cancelled
+func (c *Changeset) GetRetryableChanges() ([]types.Changeset, error) {
+	result := make([]types.Changeset, 0)
+
+	for nodeId, items := range c.Inputs {
+		for _, item := range items {
+			if item.Status != ItemStatusFailed && item.Status != ItemStatusCancelled {
+				continue
+			}
+
+			changeStruct, err := utils.ConvertStruct[map[string]interface{}, types.MediaItemChange](item.Change)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, types.Changeset{
+				MediaItemMapping: types.MediaItemMapping{NodeId: nodeId, MediaId: item.MediaId},
+				Change:           changeStruct,
+			})
+		}
+	}
+
+	return result, nil
+}
+
 func newChangesetFromRequest(r types.ChangesetCreateRequest) (*Changeset, error) {
 	inputs := make(map[string][]input)
 	outputs := make(map[string]bool)
@@ -95,16 +554,28 @@ func newChangesetFromRequest(r types.ChangesetCreateRequest) (*Changeset, error)
 		ip := input{
 			MediaId: item.MediaId,
 			Change:  mapChange,
+			Status:  ItemStatusPending,
 		}
 		inputs[item.NodeId] = append(inputs[item.NodeId], ip)
 		outputs[item.NodeId] = false
 	}
 
-	return &Changeset{
-		Id:      primitive.NewObjectID(),
-		Status:  StatusPending,
-		Type:    changesetType(r.Action),
-		Inputs:  inputs,
-		Outputs: outputs,
-	}, nil
+	c := &Changeset{
+		Id:        primitive.NewObjectID(),
+		Status:    StatusPending,
+		Type:      changesetType(r.Action),
+		Inputs:    inputs,
+		Outputs:   outputs,
+		ApplyAt:   r.ApplyAt,
+		CreatedAt: time.Now(),
+	}
+
+	// a draft only expires once it is committed
+	if r.Draft {
+		c.Status = StatusDraft
+	} else {
+		c.setReady()
+	}
+
+	return c, nil
 }
@@ -3,17 +3,73 @@ package changeset
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	mediapireMongo "github.com/egfanboy/mediapire-manager/internal/mongo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+type changesetFilter struct {
+	Statuses      []changesetStatus
+	Types         []changesetType
+	NodeId        *string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	ExpiresBefore *time.Time
+	ApplyBefore   *time.Time
+}
+
+func (f changesetFilter) toQuery() bson.M {
+	query := bson.M{}
+
+	if len(f.Statuses) > 0 {
+		query["status"] = bson.M{"$in": f.Statuses}
+	}
+
+	if len(f.Types) > 0 {
+		query["type"] = bson.M{"$in": f.Types}
+	}
+
+	if f.NodeId != nil {
+		// inputs are keyed by node id
+		query["inputs."+*f.NodeId] = bson.M{"$exists": true}
+	}
+
+	createdAt := bson.M{}
+	if f.CreatedAfter != nil {
+		createdAt["$gte"] = *f.CreatedAfter
+	}
+
+	if f.CreatedBefore != nil {
+		createdAt["$lte"] = *f.CreatedBefore
+	}
+
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	if f.ExpiresBefore != nil {
+		query["expiry"] = bson.M{"$lte": *f.ExpiresBefore}
+	}
+
+	if f.ApplyBefore != nil {
+		query["apply_at"] = bson.M{"$lte": *f.ApplyBefore}
+	}
+
+	return query
+}
+
 type changesetRepository interface {
 	Save(ctx context.Context, d *Changeset) error
 	GetById(ctx context.Context, objectId primitive.ObjectID) (*Changeset, error)
-	GetAll(ctx context.Context) ([]*Changeset, error)
+	// GetAll returns the changesets matching the filter, newest first
+	GetAll(ctx context.Context, filter changesetFilter) ([]*Changeset, error)
+	// Update reads the changeset, applies fn and saves it. fn is applied again to the latest changeset when it was modified concurrently
+	Update(ctx context.Context, objectId primitive.ObjectID, fn func(c *Changeset) error) (*Changeset, error)
 }
 
 type repo struct {
@@ -27,6 +83,9 @@ func (r *repo) getCollection() *mongo.Collection {
 }
 
 func (r *repo) Save(ctx context.Context, d *Changeset) error {
+	d.UpdatedAt = time.Now()
+	d.Version++
+
 	_, err := r.GetById(ctx, d.Id)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
@@ -52,13 +111,16 @@ func (r *repo) GetById(ctx context.Context, objectId primitive.ObjectID) (*Chang
 	return dl, err
 }
 
-func (r *repo) GetAll(ctx context.Context) ([]*Changeset, error) {
-	cur, err := r.getCollection().Find(ctx, bson.D{})
+func (r *repo) GetAll(ctx context.Context, filter changesetFilter) ([]*Changeset, error) {
+	// object ids are time based, use them to order changesets created before timestamps were tracked
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+
+	cur, err := r.getCollection().Find(ctx, filter.toQuery(), opts)
 	if err != nil {
 		return nil, err
 	}
 
-	var result []*Changeset
+	result := make([]*Changeset, 0)
 
 	err = cur.All(ctx, &result)
 	if err != nil {
@@ -68,6 +130,46 @@ func (r *repo) GetAll(ctx context.Context) ([]*Changeset, error) {
 	return result, nil
 }
 
+func (r *repo) Update(ctx context.Context, objectId primitive.ObjectID, fn func(cs *Changeset) error) (*Changeset, error) {
+	for attempt := 0; attempt < mediapireMongo.MaxUpdateAttempts; attempt++ {
+		cs, err := r.GetById(ctx, objectId)
+		if err != nil {
+			return nil, err
+		}
+
+		err = fn(cs)
+		if err != nil {
+			return nil, err
+		}
+
+		saved, err := r.replaceIfUnchanged(ctx, cs)
+		if err != nil {
+			return nil, err
+		}
+
+		if saved {
+			return cs, nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to update changeset %s since it kept being modified concurrently", objectId.Hex())
+}
+
+// replaces the changeset unless it was saved since it was read, returns whether it was replaced
+func (r *repo) replaceIfUnchanged(ctx context.Context, cs *Changeset) (bool, error) {
+	version := cs.Version
+
+	cs.UpdatedAt = time.Now()
+	cs.Version++
+
+	result, err := r.getCollection().ReplaceOne(ctx, mediapireMongo.VersionFilter(cs.Id, version), cs)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
 func newChangesetRepository(ctx context.Context) (changesetRepository, error) {
 	r := &repo{}
 
@@ -2,29 +2,77 @@ package changeset
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/egfanboy/mediapire-common/exceptions"
+	"github.com/egfanboy/mediapire-manager/internal/art"
+	changeset_template "github.com/egfanboy/mediapire-manager/internal/changeset/template"
 	"github.com/egfanboy/mediapire-manager/internal/media"
+	media_update "github.com/egfanboy/mediapire-manager/internal/media/update"
+	"github.com/egfanboy/mediapire-manager/internal/metadata"
+	"github.com/egfanboy/mediapire-manager/internal/node"
+	"github.com/egfanboy/mediapire-manager/internal/utils"
 	"github.com/egfanboy/mediapire-manager/pkg/types"
+	"github.com/egfanboy/mediapire-manager/pkg/types/pagination"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type ChangesetApi interface {
-	GetChangesets(ctx context.Context) ([]*Changeset, error)
+	GetChangesets(ctx context.Context, filter changesetFilter, pagination *pagination.ApiPaginationParams) (interface{}, error)
 	GetChangesetById(ctx context.Context, changesetId primitive.ObjectID) (*Changeset, error)
 	CreateChangeset(ctx context.Context, request types.ChangesetCreateRequest) (*Changeset, error)
+	PreviewChangeset(ctx context.Context, request types.ChangesetCreateRequest) ([]types.ChangesetPreviewItem, error)
+	SuggestTags(ctx context.Context, request types.TagSuggestionRequest) (types.TagSuggestionResponse, error)
+	DeleteMedia(ctx context.Context, request types.MediaDeleteRequest) (*Changeset, error)
+	RevertChangeset(ctx context.Context, changesetId primitive.ObjectID) (*Changeset, error)
+	CancelChangeset(ctx context.Context, changesetId primitive.ObjectID) (*Changeset, error)
+	RetryChangeset(ctx context.Context, changesetId primitive.ObjectID) (*Changeset, error)
+	// SaveArt stores art uploaded for a changeset and returns the id to reference it with
+	SaveArt(ctx context.Context, content []byte) (string, error)
+	// ResolveJsonRequest converts the JSON request to a regular request by storing or looking up the art of every change
+	ResolveJsonRequest(ctx context.Context, request types.ChangesetJsonCreateRequest) (types.ChangesetCreateRequest, error)
+	UpdateDraft(ctx context.Context, changesetId primitive.ObjectID, request types.ChangesetPatchRequest) (*Changeset, error)
+	CommitChangeset(ctx context.Context, changesetId primitive.ObjectID) (*Changeset, error)
 }
 
 type service struct {
 	repo         changesetRepository
+	snapshotRepo snapshotRepository
+	artStore     *art.Store
 	mediaService media.MediaApi
+	nodeRepo     node.NodeRepo
 }
 
-func (s *service) GetChangesets(ctx context.Context) (result []*Changeset, err error) {
+func (s *service) SaveArt(ctx context.Context, content []byte) (string, error) {
+	blobId, err := s.artStore.Save(ctx, content)
+	if err != nil {
+		log.Err(err).Msg("Failed to save art")
+		return "", err
+	}
+
+	return blobId, nil
+}
+
+func (s *service) GetChangesets(
+	ctx context.Context,
+	filter changesetFilter,
+	paginationParams *pagination.ApiPaginationParams) (result interface{}, err error) {
 	log.Info().Msg("Start: Get Changesets")
 
-	result, err = s.repo.GetAll(ctx)
+	changesets, err := s.repo.GetAll(ctx, filter)
+	if err != nil {
+		return
+	}
+
+	items := make([]types.ChangesetItem, len(changesets))
+	for i, cs := range changesets {
+		items[i] = cs.ToApiSummary()
+	}
+
+	result, err = pagination.Paginate(items, paginationParams)
 	if err != nil {
 		return
 	}
@@ -47,6 +95,18 @@ func (s *service) GetChangesetById(ctx context.Context, changesetId primitive.Ob
 
 func (s *service) CreateChangeset(ctx context.Context, request types.ChangesetCreateRequest) (result *Changeset, err error) {
 	log.Info().Msg("Start: Create Changeset")
+	if isTemplateAction(request.Action) {
+		request, err = s.expandTemplateRequest(ctx, request)
+		if err != nil {
+			return
+		}
+	}
+
+	err = s.validateRequest(ctx, request, true)
+	if err != nil {
+		return
+	}
+
 	result, err = newChangesetFromRequest(request)
 	if err != nil {
 		log.Err(err).Msg("Failed to convert request to changeset")
@@ -59,16 +119,597 @@ func (s *service) CreateChangeset(ctx context.Context, request types.ChangesetCr
 		return
 	}
 
-	// asynchronously start changeset
-	go s.delegateChangeset(result)
+	// a draft is started once it is committed and a scheduled changeset by the scheduler
+	if result.Status == StatusPending {
+		// asynchronously start changeset
+		go s.delegateChangeset(result)
+	}
 
 	log.Info().Msg("End: Create Changeset")
 	return
 }
 
+func (s *service) DeleteMedia(ctx context.Context, request types.MediaDeleteRequest) (*Changeset, error) {
+	changes := make([]types.Changeset, len(request))
+	for i, item := range request {
+		changes[i] = types.Changeset{MediaItemMapping: item}
+	}
+
+	return s.CreateChangeset(ctx, types.ChangesetCreateRequest{Action: string(TypeDelete), Changes: changes})
+}
+
+func (s *service) ResolveJsonRequest(ctx context.Context, request types.ChangesetJsonCreateRequest) (result types.ChangesetCreateRequest, err error) {
+	result = types.ChangesetCreateRequest{
+		Action:   request.Action,
+		Template: request.Template,
+		Draft:    request.Draft,
+		ApplyAt:  request.ApplyAt,
+	}
+
+	result.Changes, err = s.resolveJsonChanges(ctx, request.Changes)
+
+	return
+}
+
+// stores or looks up the art of every change and replaces it by the id of the art
+func (s *service) resolveJsonChanges(ctx context.Context, changes []types.JsonChangeset) (result []types.Changeset, err error) {
+	result = make([]types.Changeset, len(changes))
+
+	// copying the cover of a track onto a whole album only fetches it once
+	mediaArt := make(map[types.MediaItemMapping]string)
+
+	for i, item := range changes {
+		change := item.Change.MediaItemChange
+
+		artInput := item.Change.Art
+		switch {
+		case artInput == nil:
+			change.Art = ""
+		case len(artInput.Content) > 0:
+			change.Art, err = s.SaveArt(ctx, artInput.Content)
+			if err != nil {
+				return
+			}
+		case artInput.BlobId != "":
+			exists, existsErr := s.artStore.Exists(ctx, artInput.BlobId)
+			if existsErr != nil {
+				err = existsErr
+				return
+			}
+
+			if !exists {
+				err = exceptions.NewBadRequestException(fmt.Errorf("art %s was never uploaded", artInput.BlobId))
+				return
+			}
+
+			change.Art = artInput.BlobId
+		case artInput.Media != nil:
+			blobId, ok := mediaArt[*artInput.Media]
+			if !ok {
+				content, artErr := s.mediaService.GetMediaArt(ctx, artInput.Media.NodeId, artInput.Media.MediaId)
+				if artErr != nil {
+					err = exceptions.NewBadRequestException(
+						fmt.Errorf("failed to get art of media %s on node %s: %w", artInput.Media.MediaId, artInput.Media.NodeId, artErr),
+					)
+					return
+				}
+
+				blobId, err = s.SaveArt(ctx, content)
+				if err != nil {
+					return
+				}
+
+				mediaArt[*artInput.Media] = blobId
+			}
+
+			change.Art = blobId
+		default:
+			// an empty string leaves the art untouched
+			change.Art = ""
+		}
+
+		result[i] = types.Changeset{MediaItemMapping: item.MediaItemMapping, Change: change}
+	}
+
+	return
+}
+
+func (s *service) UpdateDraft(ctx context.Context, changesetId primitive.ObjectID, request types.ChangesetPatchRequest) (result *Changeset, err error) {
+	log.Info().Msg("Start: Update Draft Changeset")
+
+	cs, err := s.repo.GetById(ctx, changesetId)
+	if err != nil {
+		return
+	}
+
+	if cs.Status != StatusDraft {
+		err = exceptions.NewBadRequestException(fmt.Errorf("cannot edit changeset %s since it is %s, only drafts can be edited", cs.Id.Hex(), cs.Status))
+		return
+	}
+
+	upserts, err := s.resolveJsonChanges(ctx, request.Upsert)
+	if err != nil {
+		return
+	}
+
+	err = s.validateChanges(ctx, cs.Type, upserts, true)
+	if err != nil {
+		return
+	}
+
+	result, err = s.repo.Update(ctx, changesetId, func(c *Changeset) error {
+		// the draft may have been committed while the changes were validated
+		if c.Status != StatusDraft {
+			return exceptions.NewBadRequestException(fmt.Errorf("cannot edit changeset %s since it is %s, only drafts can be edited", c.Id.Hex(), c.Status))
+		}
+
+		for _, mapping := range request.Remove {
+			c.RemoveItem(mapping)
+		}
+
+		for _, change := range upserts {
+			err := c.UpsertItem(change)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	log.Info().Msg("End: Update Draft Changeset")
+	return
+}
+
+func (s *service) CommitChangeset(ctx context.Context, changesetId primitive.ObjectID) (result *Changeset, err error) {
+	log.Info().Msg("Start: Commit Changeset")
+
+	cs, err := s.repo.GetById(ctx, changesetId)
+	if err != nil {
+		return
+	}
+
+	if cs.Status != StatusDraft {
+		err = exceptions.NewBadRequestException(fmt.Errorf("cannot commit changeset %s since it is %s", cs.Id.Hex(), cs.Status))
+		return
+	}
+
+	changes, err := cs.GetChanges()
+	if err != nil {
+		return
+	}
+
+	if len(changes) == 0 {
+		err = exceptions.NewBadRequestException(fmt.Errorf("cannot commit changeset %s since it does not contain any change", cs.Id.Hex()))
+		return
+	}
+
+	// the media or nodes may have changed since the items were added
+	err = s.validateChanges(ctx, cs.Type, changes, true)
+	if err != nil {
+		return
+	}
+
+	result, err = s.repo.Update(ctx, changesetId, func(c *Changeset) error {
+		if c.Status != StatusDraft {
+			return exceptions.NewBadRequestException(fmt.Errorf("cannot commit changeset %s since it is %s", c.Id.Hex(), c.Status))
+		}
+
+		// a draft whose applyAt time has passed is applied right away
+		c.setReady()
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	if result.Status == StatusPending {
+		go s.delegateChangeset(result)
+	}
+
+	log.Info().Msg("End: Commit Changeset")
+	return
+}
+
+func (s *service) RevertChangeset(ctx context.Context, changesetId primitive.ObjectID) (result *Changeset, err error) {
+	log.Info().Msg("Start: Revert Changeset")
+
+	cs, err := s.repo.GetById(ctx, changesetId)
+	if err != nil {
+		return
+	}
+
+	if cs.Type != TypeUpdate {
+		err = exceptions.NewBadRequestException(fmt.Errorf("cannot revert changeset for action %s, only %s is supported", cs.Type, TypeUpdate))
+		return
+	}
+
+	if !cs.IsDone() {
+		err = exceptions.NewBadRequestException(fmt.Errorf("cannot revert changeset %s while it is %s", cs.Id.Hex(), cs.Status))
+		return
+	}
+
+	snapshots, err := s.snapshotRepo.GetByChangesetId(ctx, changesetId)
+	if err != nil {
+		log.Err(err).Msgf("Failed to get snapshots of changeset %s", changesetId.Hex())
+		return
+	}
+
+	snapshotsByMapping := make(map[types.MediaItemMapping]snapshot)
+	for _, snap := range snapshots {
+		snapshotsByMapping[types.MediaItemMapping{NodeId: snap.NodeId, MediaId: snap.MediaId}] = snap
+	}
+
+	changes, err := cs.GetChanges()
+	if err != nil {
+		return
+	}
+
+	appliedItems := make(map[types.MediaItemMapping]bool)
+	for nodeId, items := range cs.Inputs {
+		for _, item := range items {
+			appliedItems[types.MediaItemMapping{NodeId: nodeId, MediaId: item.MediaId}] = item.Status == ItemStatusApplied
+		}
+	}
+
+	request := types.ChangesetCreateRequest{Action: string(TypeUpdate), Changes: make([]types.Changeset, 0)}
+	for _, change := range changes {
+		// items that failed were left untouched
+		if !appliedItems[change.MediaItemMapping] {
+			continue
+		}
+
+		snap, ok := snapshotsByMapping[change.MediaItemMapping]
+		if !ok {
+			err = exceptions.NewBadRequestException(
+				fmt.Errorf("changeset %s has no snapshot of media %s on node %s", cs.Id.Hex(), change.MediaId, change.NodeId),
+			)
+			return
+		}
+
+		request.Changes = append(
+			request.Changes,
+			types.Changeset{MediaItemMapping: change.MediaItemMapping, Change: snap.toInverseChange(change.Change)},
+		)
+	}
+
+	if len(request.Changes) == 0 {
+		err = exceptions.NewBadRequestException(fmt.Errorf("changeset %s has no applied items to revert", cs.Id.Hex()))
+		return
+	}
+
+	// the items may have been deleted or their node may be down since the changeset was applied
+	err = s.validateRequest(ctx, request, true)
+	if err != nil {
+		return
+	}
+
+	result, err = newChangesetFromRequest(request)
+	if err != nil {
+		log.Err(err).Msg("Failed to convert revert request to changeset")
+		return
+	}
+
+	result.RevertOf = &cs.Id
+
+	err = s.repo.Save(ctx, result)
+	if err != nil {
+		log.Err(err).Msg("Failed to save changeset record")
+		return
+	}
+
+	go s.delegateChangeset(result)
+
+	log.Info().Msg("End: Revert Changeset")
+	return
+}
+
+func (s *service) CancelChangeset(ctx context.Context, changesetId primitive.ObjectID) (result *Changeset, err error) {
+	log.Info().Msg("Start: Cancel Changeset")
+
+	result, err = s.repo.Update(ctx, changesetId, func(c *Changeset) error {
+		if !c.IsActive() && c.Status != StatusScheduled {
+			return exceptions.NewBadRequestException(fmt.Errorf("cannot cancel changeset %s since it is %s", c.Id.Hex(), c.Status))
+		}
+
+		c.Cancel()
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	cancelRun(changesetId)
+
+	log.Info().Msg("End: Cancel Changeset")
+	return
+}
+
+func (s *service) RetryChangeset(ctx context.Context, changesetId primitive.ObjectID) (result *Changeset, err error) {
+	log.Info().Msg("Start: Retry Changeset")
+
+	cs, err := s.repo.GetById(ctx, changesetId)
+	if err != nil {
+		return
+	}
+
+	if cs.Status != StatusFailed && cs.Status != StatusPartiallyFailed && cs.Status != StatusCancelled {
+		err = exceptions.NewBadRequestException(fmt.Errorf("cannot retry changeset %s since it is %s", cs.Id.Hex(), cs.Status))
+		return
+	}
+
+	changes, err := cs.GetRetryableChanges()
+	if err != nil {
+		return
+	}
+
+	if len(changes) == 0 {
+		err = exceptions.NewBadRequestException(fmt.Errorf("changeset %s has no failed items to retry", cs.Id.Hex()))
+		return
+	}
+
+	for _, change := range changes {
+		if change.Change.Art == "" {
+			continue
+		}
+
+		exists, existsErr := s.artStore.Exists(ctx, change.Change.Art)
+		if existsErr != nil {
+			err = existsErr
+			return
+		}
+
+		if !exists {
+			err = exceptions.NewBadRequestException(
+				fmt.Errorf("art of media %s on node %s is no longer available, create a new changeset instead", change.MediaId, change.NodeId),
+			)
+			return
+		}
+	}
+
+	request := types.ChangesetCreateRequest{Action: string(cs.Type), Changes: changes}
+
+	err = s.validateRequest(ctx, request, true)
+	if err != nil {
+		return
+	}
+
+	result, err = newChangesetFromRequest(request)
+	if err != nil {
+		log.Err(err).Msg("Failed to convert retry request to changeset")
+		return
+	}
+
+	result.RetryOf = &cs.Id
+
+	err = s.repo.Save(ctx, result)
+	if err != nil {
+		log.Err(err).Msg("Failed to save changeset record")
+		return
+	}
+
+	go s.delegateChangeset(result)
+
+	log.Info().Msg("End: Retry Changeset")
+	return
+}
+
+func (s *service) PreviewChangeset(ctx context.Context, request types.ChangesetCreateRequest) (result []types.ChangesetPreviewItem, err error) {
+	log.Info().Msg("Start: Preview Changeset")
+	if isTemplateAction(request.Action) {
+		request, err = s.expandTemplateRequest(ctx, request)
+		if err != nil {
+			return
+		}
+	}
+
+	err = s.validateRequest(ctx, request, false)
+	if err != nil {
+		return
+	}
+
+	cs, err := newChangesetFromRequest(request)
+	if err != nil {
+		log.Err(err).Msg("Failed to convert request to changeset")
+		return nil, err
+	}
+
+	if cs.Type != TypeUpdate {
+		err = exceptions.NewBadRequestException(fmt.Errorf("cannot preview changeset for action %s, only %s is supported", cs.Type, TypeUpdate))
+		return
+	}
+
+	changes, err := cs.GetChanges()
+	if err != nil {
+		return
+	}
+
+	result, err = s.mediaService.InternalPreviewUpdateMedia(ctx, changes)
+	if err != nil {
+		return
+	}
+
+	log.Info().Msg("End: Preview Changeset")
+	return
+}
+
+func (s *service) SuggestTags(ctx context.Context, request types.TagSuggestionRequest) (result types.TagSuggestionResponse, err error) {
+	log.Info().Msg("Start: Suggest Tags")
+
+	provider, err := metadata.NewProvider()
+	if err != nil {
+		log.Err(err).Msg("Failed to create metadata provider")
+		return
+	}
+
+	mediaIds := make([]string, len(request.Items))
+	for i, item := range request.Items {
+		mediaIds[i] = item.MediaId
+	}
+
+	items, err := s.mediaService.GetMedia(ctx, []string{}, []string{}, mediaIds)
+	if err != nil {
+		return
+	}
+
+	itemsByMapping := make(map[types.MediaItemMapping]types.MediaItem)
+	for _, item := range items {
+		itemsByMapping[types.MediaItemMapping{NodeId: item.NodeId, MediaId: item.Id}] = item
+	}
+
+	result.Suggestions = make([]types.TagSuggestion, 0, len(request.Items))
+	result.Changeset = types.ChangesetCreateRequest{Action: string(TypeUpdate), Changes: make([]types.Changeset, 0)}
+
+	for _, mapping := range request.Items {
+		item, ok := itemsByMapping[mapping]
+		if !ok {
+			err = exceptions.NewBadRequestException(fmt.Errorf("media %s not found on node %s", mapping.MediaId, mapping.NodeId))
+			return
+		}
+
+		// a failed lookup only affects its item, the other items still get their suggestions
+		candidates, lookupErr := lookupTags(ctx, provider, item)
+		if lookupErr != nil {
+			// the whole request is cancelled, there is no point in looking up the other items
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				return
+			}
+
+			log.Err(lookupErr).Msgf("Failed to lookup metadata for media %s", item.Id)
+			result.Suggestions = append(
+				result.Suggestions,
+				types.TagSuggestion{MediaItemMapping: mapping, Candidates: []types.MediaItemChange{}, Error: lookupErr.Error()},
+			)
+
+			continue
+		}
+
+		result.Suggestions = append(result.Suggestions, types.TagSuggestion{MediaItemMapping: mapping, Candidates: candidates})
+
+		if len(candidates) > 0 {
+			change := candidates[0]
+			// providers know the track of every recording, only keep it when it can be written
+			if !media_update.SupportsTrack(item.Extension) {
+				change.TrackIndex, change.TrackOf = 0, 0
+			}
+
+			result.Changeset.Changes = append(result.Changeset.Changes, types.Changeset{MediaItemMapping: mapping, Change: change})
+		}
+	}
+
+	log.Info().Msg("End: Suggest Tags")
+	return
+}
+
+func lookupTags(ctx context.Context, provider metadata.Provider, item types.MediaItem) ([]types.MediaItemChange, error) {
+	query, err := newMetadataQuery(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.Lookup(ctx, query)
+}
+
+func newMetadataQuery(item types.MediaItem) (metadata.Query, error) {
+	itemMetadata, err := utils.ConvertStruct[interface{}, map[string]interface{}](item.Metadata)
+	if err != nil {
+		return metadata.Query{}, err
+	}
+
+	query := metadata.Query{}
+	query.Title, _ = itemMetadata["title"].(string)
+	query.Artist, _ = itemMetadata["artist"].(string)
+	query.Album, _ = itemMetadata["album"].(string)
+	query.Duration, _ = itemMetadata["duration"].(float64)
+
+	return query, nil
+}
+
+// converts a template request to an update request by deriving the change of every item from the template
+func (s *service) expandTemplateRequest(ctx context.Context, request types.ChangesetCreateRequest) (types.ChangesetCreateRequest, error) {
+	template, err := changeset_template.New(request.Template)
+	if err != nil {
+		return request, exceptions.NewBadRequestException(err)
+	}
+
+	mediaIds := make([]string, len(request.Changes))
+	for i, change := range request.Changes {
+		mediaIds[i] = change.MediaId
+	}
+
+	items, err := s.mediaService.GetMedia(ctx, []string{}, []string{}, mediaIds)
+	if err != nil {
+		return request, err
+	}
+
+	itemsByMapping := make(map[types.MediaItemMapping]types.MediaItem)
+	for _, item := range items {
+		itemsByMapping[types.MediaItemMapping{NodeId: item.NodeId, MediaId: item.Id}] = item
+	}
+
+	problems := make([]string, 0)
+	changes := make([]types.Changeset, 0, len(request.Changes))
+
+	for _, change := range request.Changes {
+		item, ok := itemsByMapping[change.MediaItemMapping]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("media %s not found on node %s", change.MediaId, change.NodeId))
+			continue
+		}
+
+		derived, err := template.Parse(item)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		changes = append(changes, types.Changeset{MediaItemMapping: change.MediaItemMapping, Change: derived})
+	}
+
+	if len(problems) > 0 {
+		return request, exceptions.NewBadRequestException(fmt.Errorf("cannot apply template %q: %s", request.Template, strings.Join(problems, "; ")))
+	}
+
+	return types.ChangesetCreateRequest{Action: string(TypeUpdate), Changes: changes, Draft: request.Draft, ApplyAt: request.ApplyAt}, nil
+}
+
+func (s *service) failChangeset(ctx context.Context, changesetId primitive.ObjectID, failureReason string) error {
+	_, err := s.repo.Update(ctx, changesetId, func(c *Changeset) error {
+		// a cancelled or expired changeset keeps its status
+		if !c.IsActive() {
+			return nil
+		}
+
+		c.SetFailed(failureReason)
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msgf("Failed to update change set %s", changesetId.Hex())
+	}
+
+	return err
+}
+
+// handles an error that occured while delegating the changeset
+func (s *service) handleDelegateError(ctx context.Context, changesetId primitive.ObjectID, err error) error {
+	if errors.Is(err, errChangesetStopped) || ctx.Err() != nil {
+		log.Info().Msgf("Stopped delegating changeset %s since it was cancelled or expired", changesetId.Hex())
+		return nil
+	}
+
+	// the delegation context could be cancelled at any point, record the failure regardless
+	return s.failChangeset(context.Background(), changesetId, err.Error())
+}
+
 // runs asynchronously as a goroutine
 func (s *service) delegateChangeset(cs *Changeset) error {
-	ctx := context.Background()
+	ctx, done := startRun(cs.Id)
+	defer done()
 
 	switch cs.Type {
 	case TypeUpdate:
@@ -80,28 +721,116 @@ func (s *service) delegateChangeset(cs *Changeset) error {
 
 					return err
 				}
-				err = s.mediaService.InternalUpdateMedia(ctx, cs.Id.Hex(), changes)
+
+				prepared, err := s.mediaService.InternalPrepareUpdateMedia(ctx, changes)
+				if err != nil {
+					return err
+				}
+
+				defer prepared.Cleanup()
+
+				// keep the original state of the items so the changeset can be reverted
+				itemSnapshots := prepared.Snapshots()
+				snapshots := make([]snapshot, len(itemSnapshots))
+				for i, itemSnapshot := range itemSnapshots {
+					artId := ""
+					if len(itemSnapshot.Art) > 0 {
+						artId, err = s.artStore.Save(ctx, itemSnapshot.Art)
+						if err != nil {
+							log.Err(err).Msgf("Failed to save original art of media %s", itemSnapshot.MediaId)
+							return err
+						}
+					}
+
+					snapshots[i] = newSnapshot(cs.Id, itemSnapshot, artId)
+				}
+
+				err = s.snapshotRepo.SaveAll(ctx, snapshots)
+				if err != nil {
+					log.Err(err).Msgf("Failed to save snapshots of changeset %s", cs.Id.Hex())
+					return err
+				}
+
+				chunks := prepared.Chunks()
+
+				// the number of messages sent to each node needs to be saved before any of them can be acknowledged
+				updated, err := s.repo.Update(ctx, cs.Id, func(c *Changeset) error {
+					if !c.IsActive() {
+						return errChangesetStopped
+					}
+
+					c.Chunks = make(map[string]int)
+					for nodeId, nodeChunks := range chunks {
+						c.Chunks[nodeId] = len(nodeChunks)
+					}
+
+					c.ChunkItems = chunks
+
+					for _, item := range prepared.Rewritten() {
+						c.SetItemStatus(item, ItemStatusRewritten, "")
+					}
+
+					for _, failure := range prepared.Failures() {
+						c.SetItemStatus(failure.MediaItemMapping, ItemStatusFailed, failure.Err.Error())
+
+						// the first verification failure explains the changeset better than the generic reason
+						var verificationErr *media_update.VerificationError
+						if c.FailureReason == "" && errors.As(failure.Err, &verificationErr) {
+							c.FailureReason = verificationErr.Error()
+						}
+					}
+
+					// nodes without any rewritten item will never be sent a message
+					for nodeId := range c.Inputs {
+						if len(chunks[nodeId]) == 0 {
+							c.SetNodeFailed(nodeId, fmt.Sprintf("every item of node %s failed to be rewritten", nodeId))
+						}
+					}
+
+					c.ResolveStatus()
+					if !c.IsDone() {
+						c.Status = StatusInProgress
+					}
+
+					return nil
+				})
 				if err != nil {
 					return err
 				}
 
-				return nil
+				if updated.IsDone() {
+					return nil
+				}
+
+				return prepared.Publish(ctx, cs.Id.Hex())
 			}(ctx, cs)
 			if err != nil {
-				cs.Status = StatusFailed
-				cs.FailureReason = err.Error()
+				return s.handleDelegateError(ctx, cs.Id, err)
 			}
 
-			err = s.repo.Save(ctx, cs)
-			if err != nil {
-				log.Err(err).Msgf("Failed to update change set %s", cs.Id.Hex())
+			return nil
+		}
 
-				return err
+	case TypeDelete:
+		_, err := s.repo.Update(ctx, cs.Id, func(c *Changeset) error {
+			if !c.IsActive() {
+				return errChangesetStopped
 			}
 
+			c.Status = StatusInProgress
 			return nil
+		})
+		if err != nil {
+			return s.handleDelegateError(ctx, cs.Id, err)
+		}
+
+		err = s.mediaService.InternalDeleteMedia(ctx, cs.Id.Hex(), cs.GetMediaIdsByNode())
+		if err != nil {
+			return s.handleDelegateError(ctx, cs.Id, err)
 		}
 
+		return nil
+
 	default:
 		cs.Status = StatusFailed
 		cs.FailureReason = fmt.Sprintf("changeset was for action %s which is not supported", cs.Type)
@@ -118,19 +847,41 @@ func (s *service) delegateChangeset(cs *Changeset) error {
 }
 
 func newChangesetService(ctx context.Context) (ChangesetApi, error) {
+	return newService(ctx)
+}
+
+func newService(ctx context.Context) (*service, error) {
 	repo, err := newChangesetRepository(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	snapshotRepo, err := newSnapshotRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	artStore, err := art.NewStore()
+	if err != nil {
+		return nil, err
+	}
+
 	mediaService, err := media.NewMediaService()
 	if err != nil {
 		return nil, err
 	}
 
+	nodeRepo, err := node.NewNodeRepo()
+	if err != nil {
+		return nil, err
+	}
+
 	s := &service{
 		repo:         repo,
+		snapshotRepo: snapshotRepo,
+		artStore:     artStore,
 		mediaService: mediaService,
+		nodeRepo:     nodeRepo,
 	}
 
 	return s, nil
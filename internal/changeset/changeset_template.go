@@ -0,0 +1,10 @@
+package changeset
+
+const (
+	// derives the tags of the items from their file name
+	actionTagFromFilename = "tag_from_filename"
+)
+
+func isTemplateAction(action string) bool {
+	return action == actionTagFromFilename
+}
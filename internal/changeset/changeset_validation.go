@@ -0,0 +1,152 @@
+package changeset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/egfanboy/mediapire-common/exceptions"
+	media_update "github.com/egfanboy/mediapire-manager/internal/media/update"
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+)
+
+var clearableFields = map[string]bool{
+	types.FieldName:    true,
+	types.FieldArtist:  true,
+	types.FieldAlbum:   true,
+	types.FieldComment: true,
+	types.FieldGenre:   true,
+	types.FieldArt:     true,
+}
+
+// returns the problems of a single change to an existing item. Fields that are unsupported for the
+// extension of the item are only checked when checkSupport is set
+func validateChange(item types.MediaItem, changeType changesetType, change types.MediaItemChange, checkSupport bool) []string {
+	problems := make([]string, 0)
+
+	// a delete does not change any field
+	if changeType != TypeUpdate {
+		return problems
+	}
+
+	for _, field := range change.Clear {
+		if !clearableFields[field] {
+			problems = append(problems, fmt.Sprintf("field %q cannot be cleared", field))
+		}
+	}
+
+	if checkSupport {
+		problems = append(problems, unsupportedChanges(item, change)...)
+	}
+
+	return problems
+}
+
+// returns the fields of the change that cannot be written to the item because of its extension
+func unsupportedChanges(item types.MediaItem, change types.MediaItemChange) []string {
+	problems := make([]string, 0)
+
+	if (change.TrackIndex != 0 || change.TrackOf != 0) && !media_update.SupportsTrack(item.Extension) {
+		problems = append(problems, fmt.Sprintf("track cannot be updated for %s files", item.Extension))
+	}
+
+	if change.Art != "" && !media_update.SupportsArt(item.Extension) {
+		problems = append(problems, fmt.Sprintf("art cannot be updated for %s files", item.Extension))
+	}
+
+	for _, field := range change.Clear {
+		if field == types.FieldArt && !media_update.SupportsArt(item.Extension) {
+			problems = append(problems, fmt.Sprintf("art cannot be removed from %s files", item.Extension))
+		}
+	}
+
+	return problems
+}
+
+// validates the request before anything is saved so problems are reported right away instead of failing the changeset.
+// A preview skips the fields unsupported by the extension of an item since it reports them as warnings of the item
+func (s *service) validateRequest(ctx context.Context, request types.ChangesetCreateRequest, checkSupport bool) error {
+	changeType := changesetType(request.Action)
+	if changeType != TypeUpdate && changeType != TypeDelete {
+		return exceptions.NewBadRequestException(fmt.Errorf("unknown changeset action %q", request.Action))
+	}
+
+	// items are added to a draft after it is created
+	if len(request.Changes) == 0 && !request.Draft {
+		return exceptions.NewBadRequestException(errors.New("changeset does not contain any change"))
+	}
+
+	if request.ApplyAt != nil && !request.ApplyAt.After(time.Now()) {
+		return exceptions.NewBadRequestException(fmt.Errorf("applyAt %s is not in the future", request.ApplyAt.Format(time.RFC3339)))
+	}
+
+	return s.validateChanges(ctx, changeType, request.Changes, checkSupport)
+}
+
+// validates every change against the media and nodes, returns a bad request listing the problems of every item
+func (s *service) validateChanges(ctx context.Context, changeType changesetType, changes []types.Changeset, checkSupport bool) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	nodes, err := s.nodeRepo.GetAllNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	nodesUp := make(map[string]bool)
+	for _, node := range nodes {
+		nodesUp[node.Id] = node.IsUp
+	}
+
+	mediaIds := make([]string, len(changes))
+	for i, change := range changes {
+		mediaIds[i] = change.MediaId
+	}
+
+	items, err := s.mediaService.GetMedia(ctx, []string{}, []string{}, mediaIds)
+	if err != nil {
+		return err
+	}
+
+	itemsByMapping := make(map[types.MediaItemMapping]types.MediaItem)
+	for _, item := range items {
+		itemsByMapping[types.MediaItemMapping{NodeId: item.NodeId, MediaId: item.Id}] = item
+	}
+
+	problems := make([]string, 0)
+	seen := make(map[types.MediaItemMapping]bool)
+
+	for _, change := range changes {
+		itemProblems := make([]string, 0)
+
+		isUp, ok := nodesUp[change.NodeId]
+		if !ok {
+			itemProblems = append(itemProblems, "node does not exist")
+		} else if !isUp {
+			itemProblems = append(itemProblems, "node is down")
+		} else if item, ok := itemsByMapping[change.MediaItemMapping]; !ok {
+			itemProblems = append(itemProblems, "media does not exist")
+		} else {
+			itemProblems = append(itemProblems, validateChange(item, changeType, change.Change, checkSupport)...)
+		}
+
+		if seen[change.MediaItemMapping] {
+			itemProblems = append(itemProblems, "media is changed more than once")
+		}
+
+		seen[change.MediaItemMapping] = true
+
+		for _, problem := range itemProblems {
+			problems = append(problems, fmt.Sprintf("media %s on node %s: %s", change.MediaId, change.NodeId, problem))
+		}
+	}
+
+	if len(problems) > 0 {
+		return exceptions.NewBadRequestException(fmt.Errorf("invalid changeset: %s", strings.Join(problems, "; ")))
+	}
+
+	return nil
+}
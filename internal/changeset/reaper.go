@@ -0,0 +1,202 @@
+package changeset
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/egfanboy/mediapire-manager/internal/app"
+	"github.com/egfanboy/mediapire-manager/internal/art"
+	"github.com/egfanboy/mediapire-manager/internal/media"
+	"github.com/rs/zerolog/log"
+)
+
+type reaper struct {
+	repo         changesetRepository
+	snapshotRepo snapshotRepository
+	artStore     *art.Store
+	mediaService media.MediaApi
+	syncService  media.MediaSync
+}
+
+// media hosts do not acknowledge deletes, the nodes of every delete in progress are synced
+// to find which of its items are gone
+func (r *reaper) confirmDeletes(ctx context.Context) {
+	changesets, err := r.repo.GetAll(ctx, changesetFilter{Statuses: []changesetStatus{StatusInProgress}})
+	if err != nil {
+		log.Err(err).Msg("failed to get changesets in progress")
+		return
+	}
+
+	for _, cs := range changesets {
+		if cs.Type != TypeDelete {
+			continue
+		}
+
+		remaining := make(map[string]map[string]bool)
+
+		for nodeId, done := range cs.Outputs {
+			if done {
+				continue
+			}
+
+			err = r.syncService.SyncNodeMedia(ctx, nodeId)
+			if err != nil {
+				log.Err(err).Msgf("failed to sync node %s to confirm deletes of changeset %s", nodeId, cs.Id.Hex())
+				continue
+			}
+
+			items, err := r.mediaService.GetMedia(ctx, []string{}, []string{nodeId}, cs.GetMediaIdsByNode()[nodeId])
+			if err != nil {
+				log.Err(err).Msgf("failed to get media of node %s to confirm deletes of changeset %s", nodeId, cs.Id.Hex())
+				continue
+			}
+
+			remaining[nodeId] = make(map[string]bool)
+			for _, item := range items {
+				remaining[nodeId][item.Id] = true
+			}
+		}
+
+		if len(remaining) == 0 {
+			continue
+		}
+
+		_, err := r.repo.Update(ctx, cs.Id, func(c *Changeset) error {
+			// the changeset may have expired or been cancelled since it was fetched
+			if !c.IsActive() {
+				return nil
+			}
+
+			for nodeId, mediaIds := range remaining {
+				c.ConfirmDeleted(nodeId, mediaIds)
+			}
+
+			c.ResolveStatus()
+
+			return nil
+		})
+		if err != nil {
+			log.Err(err).Msgf("failed to confirm deletes of changeset %s", cs.Id.Hex())
+		}
+	}
+}
+
+// fails every active changeset that expired
+func (r *reaper) reap(ctx context.Context) {
+	now := time.Now()
+
+	expired, err := r.repo.GetAll(ctx, changesetFilter{
+		Statuses:      []changesetStatus{StatusPending, StatusInProgress},
+		ExpiresBefore: &now,
+	})
+	if err != nil {
+		log.Err(err).Msg("failed to get expired changesets")
+		return
+	}
+
+	for _, cs := range expired {
+		log.Info().Msgf("Changeset %s expired", cs.Id.Hex())
+
+		_, err := r.repo.Update(ctx, cs.Id, func(c *Changeset) error {
+			// the changeset may have completed since it was fetched
+			if !c.IsActive() {
+				return nil
+			}
+
+			c.SetFailed(fmt.Sprintf("changeset timed out, not every node responded before %s", c.Expiry.Format(time.RFC3339)))
+			return nil
+		})
+		if err != nil {
+			log.Err(err).Msgf("failed to expire changeset %s", cs.Id.Hex())
+			continue
+		}
+
+		cancelRun(cs.Id)
+	}
+}
+
+// deletes the art that is no longer referenced. Complete changesets do not need their art anymore
+// while the others can still be retried, snapshots need their art to revert a changeset
+func (r *reaper) collectArt(ctx context.Context) {
+	changesets, err := r.repo.GetAll(ctx, changesetFilter{
+		Statuses: []changesetStatus{
+			StatusDraft, StatusScheduled, StatusPending, StatusInProgress, StatusFailed, StatusPartiallyFailed, StatusCancelled,
+		},
+	})
+	if err != nil {
+		log.Err(err).Msg("failed to get changesets referencing art")
+		return
+	}
+
+	referenced := make(map[string]bool)
+	for _, cs := range changesets {
+		for _, artId := range cs.GetArtIds() {
+			referenced[artId] = true
+		}
+	}
+
+	snapshotArtIds, err := r.snapshotRepo.GetArtIds(ctx)
+	if err != nil {
+		log.Err(err).Msg("failed to get art referenced by snapshots")
+		return
+	}
+
+	for _, artId := range snapshotArtIds {
+		referenced[artId] = true
+	}
+
+	err = r.artStore.CollectGarbage(ctx, referenced)
+	if err != nil {
+		log.Err(err).Msg("failed to collect unreferenced art")
+	}
+}
+
+// StartReaper periodically confirms deletes, fails the changesets that expired and deletes unreferenced art until the context is done
+func StartReaper(ctx context.Context) error {
+	repo, err := newChangesetRepository(ctx)
+	if err != nil {
+		return err
+	}
+
+	snapshotRepo, err := newSnapshotRepository(ctx)
+	if err != nil {
+		return err
+	}
+
+	artStore, err := art.NewStore()
+	if err != nil {
+		return err
+	}
+
+	mediaService, err := media.NewMediaService()
+	if err != nil {
+		return err
+	}
+
+	syncService, err := media.NewMediaSyncService(ctx)
+	if err != nil {
+		return err
+	}
+
+	r := &reaper{repo: repo, snapshotRepo: snapshotRepo, artStore: artStore, mediaService: mediaService, syncService: syncService}
+	interval := time.Duration(app.GetApp().Config.Changesets.ReaperIntervalSeconds) * time.Second
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.confirmDeletes(ctx)
+				r.reap(ctx)
+				r.collectArt(ctx)
+			}
+		}
+	}()
+
+	return nil
+}
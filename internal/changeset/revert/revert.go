@@ -0,0 +1,65 @@
+package changeset_revert
+
+import (
+	"strconv"
+	"strings"
+
+	media_update "github.com/egfanboy/mediapire-manager/internal/media/update"
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+)
+
+// Original is the state of a media item before a changeset was applied to it
+type Original struct {
+	// tags as read by ffprobe with lowercased keys
+	Tags map[string]string
+	// id of the album cover in the art store, empty when the item had no cover
+	ArtId     string
+	Extension string
+}
+
+// InverseChange builds the change that restores the item to its original state, only the fields set or cleared by the applied change are restored
+func (o Original) InverseChange(applied types.MediaItemChange) (change types.MediaItemChange) {
+	cleared := make(map[string]bool)
+	for _, field := range applied.Clear {
+		cleared[field] = true
+	}
+
+	restore := func(value *string, appliedValue string, key string, field string) {
+		if appliedValue == "" && !cleared[field] {
+			return
+		}
+
+		*value = o.Tags[key]
+		if *value == "" {
+			change.Clear = append(change.Clear, field)
+		}
+	}
+
+	restore(&change.Name, applied.Name, "title", types.FieldName)
+	restore(&change.Artist, applied.Artist, "artist", types.FieldArtist)
+	restore(&change.Album, applied.Album, "album", types.FieldAlbum)
+	restore(&change.Comment, applied.Comment, "comment", types.FieldComment)
+	restore(&change.Genre, applied.Genre, "genre", types.FieldGenre)
+
+	// an update without a track clears it so the track is always restored on items that support it.
+	// It is stored as 2 or 2/10, no track leaves both at 0 which clears it
+	if track := o.Tags["track"]; track != "" && media_update.SupportsTrack(o.Extension) {
+		index, of, _ := strings.Cut(track, "/")
+
+		change.TrackIndex, _ = strconv.Atoi(strings.TrimSpace(index))
+		change.TrackOf, _ = strconv.Atoi(strings.TrimSpace(of))
+	}
+
+	if applied.Art == "" && !cleared[types.FieldArt] {
+		return
+	}
+
+	if o.ArtId != "" {
+		change.Art = o.ArtId
+	} else if applied.Art != "" {
+		// the item did not have a cover before the changeset added one
+		change.Clear = append(change.Clear, types.FieldArt)
+	}
+
+	return
+}
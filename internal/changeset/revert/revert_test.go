@@ -0,0 +1,82 @@
+package changeset_revert
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+)
+
+func TestInverseChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		original Original
+		applied  types.MediaItemChange
+		want     types.MediaItemChange
+	}{
+		{
+			name:     "restores the fields set by the change",
+			original: Original{Tags: map[string]string{"title": "Old title", "artist": "Old artist", "album": "Album"}, Extension: "flac"},
+			applied:  types.MediaItemChange{Name: "New title", Artist: "New artist"},
+			want:     types.MediaItemChange{Name: "Old title", Artist: "Old artist"},
+		},
+		{
+			name:     "clears fields that were empty before the change",
+			original: Original{Tags: map[string]string{"title": "Title"}, Extension: "flac"},
+			applied:  types.MediaItemChange{Genre: "Rock"},
+			want:     types.MediaItemChange{Clear: []string{types.FieldGenre}},
+		},
+		{
+			name:     "restores cleared fields",
+			original: Original{Tags: map[string]string{"comment": "Comment"}, Extension: "flac"},
+			applied:  types.MediaItemChange{Clear: []string{types.FieldComment}},
+			want:     types.MediaItemChange{Comment: "Comment"},
+		},
+		{
+			name:     "restores a track with a total",
+			original: Original{Tags: map[string]string{"track": "2/10"}, Extension: "mp3"},
+			applied:  types.MediaItemChange{Name: "Title"},
+			want:     types.MediaItemChange{TrackIndex: 2, TrackOf: 10, Clear: []string{types.FieldName}},
+		},
+		{
+			name:     "restores a track without a total",
+			original: Original{Tags: map[string]string{"title": "Title", "track": "5"}, Extension: "mp3"},
+			applied:  types.MediaItemChange{TrackIndex: 6, TrackOf: 12},
+			want:     types.MediaItemChange{TrackIndex: 5},
+		},
+		{
+			name:     "does not restore the track of items that do not support it",
+			original: Original{Tags: map[string]string{"title": "Title", "track": "5"}, Extension: "flac"},
+			applied:  types.MediaItemChange{Name: "New title"},
+			want:     types.MediaItemChange{Name: "Title"},
+		},
+		{
+			name:     "restores the original cover",
+			original: Original{Tags: map[string]string{}, ArtId: "original-art", Extension: "mp3"},
+			applied:  types.MediaItemChange{Art: "new-art"},
+			want:     types.MediaItemChange{Art: "original-art"},
+		},
+		{
+			name:     "removes a cover added to an item without one",
+			original: Original{Tags: map[string]string{}, Extension: "mp3"},
+			applied:  types.MediaItemChange{Art: "new-art"},
+			want:     types.MediaItemChange{Clear: []string{types.FieldArt}},
+		},
+		{
+			name:     "leaves the cover untouched when the change did not touch it",
+			original: Original{Tags: map[string]string{"title": "Title"}, ArtId: "original-art", Extension: "mp3"},
+			applied:  types.MediaItemChange{Name: "New title"},
+			want:     types.MediaItemChange{Name: "Title"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.original.InverseChange(tt.applied)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("InverseChange(%+v) = %+v, want %+v", tt.applied, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package changeset
+
+import (
+	"context"
+	"time"
+
+	"github.com/egfanboy/mediapire-manager/internal/app"
+	"github.com/rs/zerolog/log"
+)
+
+type scheduler struct {
+	service *service
+}
+
+// starts every scheduled changeset whose applyAt time is reached
+func (s *scheduler) startDue(ctx context.Context) {
+	now := time.Now()
+
+	due, err := s.service.repo.GetAll(ctx, changesetFilter{
+		Statuses:    []changesetStatus{StatusScheduled},
+		ApplyBefore: &now,
+	})
+	if err != nil {
+		log.Err(err).Msg("failed to get due changesets")
+		return
+	}
+
+	for _, cs := range due {
+		started := false
+
+		updated, err := s.service.repo.Update(ctx, cs.Id, func(c *Changeset) error {
+			// the changeset may have been cancelled since it was fetched
+			if c.Status != StatusScheduled {
+				return nil
+			}
+
+			c.Status = StatusPending
+			c.setExpiry()
+			started = true
+
+			return nil
+		})
+		if err != nil {
+			log.Err(err).Msgf("failed to start scheduled changeset %s", cs.Id.Hex())
+			continue
+		}
+
+		if !started {
+			continue
+		}
+
+		log.Info().Msgf("Starting scheduled changeset %s", cs.Id.Hex())
+
+		go s.service.delegateChangeset(updated)
+	}
+}
+
+// StartScheduler periodically starts the scheduled changesets that are due until the context is done.
+// Changesets that became due while the manager was down are started right away
+func StartScheduler(ctx context.Context) error {
+	svc, err := newService(ctx)
+	if err != nil {
+		return err
+	}
+
+	s := &scheduler{service: svc}
+	interval := time.Duration(app.GetApp().Config.Changesets.SchedulerIntervalSeconds) * time.Second
+
+	go func() {
+		s.startDue(ctx)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.startDue(ctx)
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package changeset
+
+import (
+	changeset_revert "github.com/egfanboy/mediapire-manager/internal/changeset/revert"
+	"github.com/egfanboy/mediapire-manager/internal/media"
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// snapshot is the state of an item before a changeset was applied to it
+type snapshot struct {
+	Id          primitive.ObjectID `bson:"_id,omitempty"`
+	ChangesetId primitive.ObjectID `bson:"changeset_id"`
+	NodeId      string             `bson:"node_id"`
+	MediaId     string             `bson:"media_id"`
+	Extension   string             `bson:"extension"`
+	// tags as read by ffprobe with lowercased keys
+	Tags map[string]string `bson:"tags"`
+	// id of the album cover in the art store, empty when the item had no cover
+	ArtId string `bson:"art_id,omitempty"`
+}
+
+func newSnapshot(changesetId primitive.ObjectID, s media.ItemSnapshot, artId string) snapshot {
+	return snapshot{
+		Id:          primitive.NewObjectID(),
+		ChangesetId: changesetId,
+		NodeId:      s.NodeId,
+		MediaId:     s.MediaId,
+		Extension:   s.Extension,
+		Tags:        s.Tags,
+		ArtId:       artId,
+	}
+}
+
+// builds the change that restores the item to the snapshot, only the fields set or cleared by the applied change are restored
+func (s snapshot) toInverseChange(applied types.MediaItemChange) types.MediaItemChange {
+	return changeset_revert.Original{Tags: s.Tags, ArtId: s.ArtId, Extension: s.Extension}.InverseChange(applied)
+}
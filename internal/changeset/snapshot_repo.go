@@ -0,0 +1,78 @@
+package changeset
+
+import (
+	"context"
+
+	mediapireMongo "github.com/egfanboy/mediapire-manager/internal/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type snapshotRepository interface {
+	SaveAll(ctx context.Context, snapshots []snapshot) error
+	GetByChangesetId(ctx context.Context, changesetId primitive.ObjectID) ([]snapshot, error)
+	// GetArtIds returns the ids of the art referenced by every snapshot
+	GetArtIds(ctx context.Context) ([]string, error)
+}
+
+type snapshotRepo struct {
+}
+
+func (r *snapshotRepo) getCollection() *mongo.Collection {
+	// TODO: do not ignore error but panic, without causing everything else to break
+	collection, _ := mediapireMongo.NewCollection("changeset_snapshots")
+
+	return collection
+}
+
+func (r *snapshotRepo) SaveAll(ctx context.Context, snapshots []snapshot) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(snapshots))
+	for i, s := range snapshots {
+		documents[i] = s
+	}
+
+	_, err := r.getCollection().InsertMany(ctx, documents)
+
+	return err
+}
+
+func (r *snapshotRepo) GetByChangesetId(ctx context.Context, changesetId primitive.ObjectID) ([]snapshot, error) {
+	cur, err := r.getCollection().Find(ctx, bson.M{"changeset_id": changesetId})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]snapshot, 0)
+
+	err = cur.All(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *snapshotRepo) GetArtIds(ctx context.Context) ([]string, error) {
+	values, err := r.getCollection().Distinct(ctx, "art_id", bson.M{"art_id": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if artId, ok := v.(string); ok {
+			result = append(result, artId)
+		}
+	}
+
+	return result, nil
+}
+
+func newSnapshotRepository(ctx context.Context) (snapshotRepository, error) {
+	return &snapshotRepo{}, nil
+}
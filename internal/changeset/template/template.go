@@ -0,0 +1,160 @@
+package changeset_template
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	media_update "github.com/egfanboy/mediapire-manager/internal/media/update"
+	"github.com/egfanboy/mediapire-manager/internal/utils"
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+)
+
+const (
+	placeholderTitle   = "title"
+	placeholderArtist  = "artist"
+	placeholderAlbum   = "album"
+	placeholderGenre   = "genre"
+	placeholderComment = "comment"
+	placeholderTrack   = "track"
+	placeholderTrackOf = "trackOf"
+)
+
+var (
+	placeholderRegEx = regexp.MustCompile(`%([a-zA-Z]+)%`)
+
+	// key of each placeholder in the metadata of a media item
+	placeholderMetadataKeys = map[string]string{
+		placeholderTitle:   "title",
+		placeholderArtist:  "artist",
+		placeholderAlbum:   "album",
+		placeholderGenre:   "genre",
+		placeholderComment: "comment",
+		placeholderTrack:   "trackIndex",
+		placeholderTrackOf: "trackOf",
+	}
+)
+
+// Template maps the placeholders of a pattern such as %track% - %artist% - %title% to the tags of a media item
+type Template struct {
+	raw          string
+	placeholders []string
+	pattern      *regexp.Regexp
+}
+
+// New compiles the template, every placeholder must be known
+func New(raw string) (*Template, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, errors.New("template cannot be empty")
+	}
+
+	t := &Template{raw: raw}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+
+	lastIndex := 0
+	for _, match := range placeholderRegEx.FindAllStringSubmatchIndex(raw, -1) {
+		placeholder := raw[match[2]:match[3]]
+		if _, ok := placeholderMetadataKeys[placeholder]; !ok {
+			return nil, fmt.Errorf("unknown placeholder %%%s%% in template", placeholder)
+		}
+
+		pattern.WriteString(regexp.QuoteMeta(raw[lastIndex:match[0]]))
+
+		if placeholder == placeholderTrack || placeholder == placeholderTrackOf {
+			pattern.WriteString(`(\d+)`)
+		} else {
+			pattern.WriteString(`(.+?)`)
+		}
+
+		t.placeholders = append(t.placeholders, placeholder)
+		lastIndex = match[1]
+	}
+
+	if len(t.placeholders) == 0 {
+		return nil, errors.New("template does not contain any placeholder")
+	}
+
+	pattern.WriteString(regexp.QuoteMeta(raw[lastIndex:]))
+	pattern.WriteString("$")
+
+	compiled, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, err
+	}
+
+	t.pattern = compiled
+
+	return t, nil
+}
+
+func trimExtension(item types.MediaItem) string {
+	return strings.TrimSuffix(item.Name, "."+item.Extension)
+}
+
+// Parse derives the change to the tags of the item from its file name
+func (t *Template) Parse(item types.MediaItem) (types.MediaItemChange, error) {
+	change := types.MediaItemChange{}
+	name := trimExtension(item)
+
+	match := t.pattern.FindStringSubmatch(name)
+	if match == nil {
+		return change, fmt.Errorf("name %q of media %s does not match template %q", item.Name, item.Id, t.raw)
+	}
+
+	metadata, err := utils.ConvertStruct[interface{}, map[string]interface{}](item.Metadata)
+	if err != nil {
+		return change, err
+	}
+
+	// placeholders of the template override the current track
+	keepCurrentTrack(item, metadata, &change)
+
+	for i, placeholder := range t.placeholders {
+		value := strings.TrimSpace(match[i+1])
+
+		switch placeholder {
+		case placeholderTitle:
+			change.Name = value
+		case placeholderArtist:
+			change.Artist = value
+		case placeholderAlbum:
+			change.Album = value
+		case placeholderGenre:
+			change.Genre = value
+		case placeholderComment:
+			change.Comment = value
+		case placeholderTrack, placeholderTrackOf:
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return change, err
+			}
+
+			if placeholder == placeholderTrack {
+				change.TrackIndex = number
+			} else {
+				change.TrackOf = number
+			}
+		}
+	}
+
+	return change, nil
+}
+
+// sets the current track of the item on the change since an update without one clears it
+func keepCurrentTrack(item types.MediaItem, metadata map[string]interface{}, change *types.MediaItemChange) {
+	if !media_update.SupportsTrack(item.Extension) {
+		return
+	}
+
+	if trackIndex, ok := metadata[placeholderMetadataKeys[placeholderTrack]].(float64); ok {
+		change.TrackIndex = int(trackIndex)
+	}
+
+	if trackOf, ok := metadata[placeholderMetadataKeys[placeholderTrackOf]].(float64); ok {
+		change.TrackOf = int(trackOf)
+	}
+}
@@ -0,0 +1,124 @@
+package changeset_template
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		item     types.MediaItem
+		want     types.MediaItemChange
+		wantErr  bool
+	}{
+		{
+			name:     "track without a total on an untagged item",
+			template: "%track% - %artist% - %title%",
+			item:     types.MediaItem{Id: "1", Name: "05 - Artist - Title.mp3", Extension: "mp3", Metadata: map[string]interface{}{}},
+			want:     types.MediaItemChange{TrackIndex: 5, Artist: "Artist", Name: "Title"},
+		},
+		{
+			name:     "track keeps the current total",
+			template: "%track% - %title%",
+			item: types.MediaItem{
+				Id:        "1",
+				Name:      "03 - Title.mp3",
+				Extension: "mp3",
+				Metadata:  map[string]interface{}{"trackIndex": 1, "trackOf": 12},
+			},
+			want: types.MediaItemChange{TrackIndex: 3, TrackOf: 12, Name: "Title"},
+		},
+		{
+			name:     "template without a track keeps the current track",
+			template: "%artist% - %title%",
+			item: types.MediaItem{
+				Id:        "1",
+				Name:      "Artist - Title.mp3",
+				Extension: "mp3",
+				Metadata:  map[string]interface{}{"trackIndex": 2, "trackOf": 10},
+			},
+			want: types.MediaItemChange{TrackIndex: 2, TrackOf: 10, Artist: "Artist", Name: "Title"},
+		},
+		{
+			name:     "track and total",
+			template: "%track% of %trackOf% - %title%",
+			item:     types.MediaItem{Id: "1", Name: "2 of 10 - Title.mp3", Extension: "mp3", Metadata: map[string]interface{}{}},
+			want:     types.MediaItemChange{TrackIndex: 2, TrackOf: 10, Name: "Title"},
+		},
+		{
+			name:     "current track is not kept for extensions without track support",
+			template: "%artist% - %title%",
+			item: types.MediaItem{
+				Id:        "1",
+				Name:      "Artist - Title.flac",
+				Extension: "flac",
+				Metadata:  map[string]interface{}{"trackIndex": 2, "trackOf": 10},
+			},
+			want: types.MediaItemChange{Artist: "Artist", Name: "Title"},
+		},
+		{
+			name:     "values are trimmed",
+			template: "%artist%-%album%-%title%",
+			item:     types.MediaItem{Id: "1", Name: "Artist - Album - Title.mp3", Extension: "mp3", Metadata: map[string]interface{}{}},
+			want:     types.MediaItemChange{Artist: "Artist", Album: "Album", Name: "Title"},
+		},
+		{
+			name:     "name does not match the template",
+			template: "%track% - %title%",
+			item:     types.MediaItem{Id: "1", Name: "Title.mp3", Extension: "mp3", Metadata: map[string]interface{}{}},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := New(tt.template)
+			if err != nil {
+				t.Fatalf("New(%q) returned an error: %v", tt.template, err)
+			}
+
+			got, err := template.Parse(tt.item)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %+v, expected an error", tt.item.Name, got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Parse(%q) returned an error: %v", tt.item.Name, err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.item.Name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  bool
+	}{
+		{name: "valid", template: "%track% - %title%"},
+		{name: "empty", template: " ", wantErr: true},
+		{name: "no placeholder", template: "title", wantErr: true},
+		{name: "unknown placeholder", template: "%year% - %title%", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New(%q) error = %v, wantErr %v", tt.template, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package media
+
+import (
+	"context"
+	"sync"
+
+	"github.com/egfanboy/mediapire-manager/internal/art"
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+)
+
+// writes the art blobs referenced by changes to files so ffmpeg can use them, every blob is only written once
+type artFileCache struct {
+	store   *art.Store
+	workDir string
+
+	mu    sync.Mutex
+	paths map[string]string
+}
+
+// returns the change with its art replaced by the path to the art on disk
+func (c *artFileCache) resolve(ctx context.Context, change types.MediaItemChange) (types.MediaItemChange, error) {
+	if change.Art == "" {
+		return change, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if artPath, ok := c.paths[change.Art]; ok {
+		change.Art = artPath
+		return change, nil
+	}
+
+	artPath, err := c.store.WriteToFile(ctx, change.Art, c.workDir)
+	if err != nil {
+		return change, err
+	}
+
+	c.paths[change.Art] = artPath
+	change.Art = artPath
+
+	return change, nil
+}
+
+func newArtFileCache(workDir string) (*artFileCache, error) {
+	store, err := art.NewStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return &artFileCache{store: store, workDir: workDir, paths: make(map[string]string)}, nil
+}
@@ -1,10 +1,13 @@
 package media
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/egfanboy/mediapire-manager/internal/app"
+	"github.com/egfanboy/mediapire-manager/internal/transfer"
 	"github.com/egfanboy/mediapire-manager/pkg/types"
 	"github.com/egfanboy/mediapire-manager/pkg/types/pagination"
 	"github.com/rs/zerolog/log"
@@ -22,6 +25,16 @@ const (
 	queryParamSortBy = "sortBy"
 )
 
+// deletes media through a changeset. The changeset package depends on this package so it registers the delete instead
+type mediaDeleter func(ctx context.Context, request types.MediaDeleteRequest) (types.ChangesetItem, error)
+
+var deleteMedia mediaDeleter
+
+// RegisterMediaDeleter sets how media is deleted so that the delete can be tracked
+func RegisterMediaDeleter(deleter mediaDeleter) {
+	deleteMedia = deleter
+}
+
 type mediaController struct {
 	builders []func() router.RouteBuilder
 	service  MediaApi
@@ -138,8 +151,11 @@ func (c mediaController) DeleteMedia() router.RouteBuilder {
 				return nil, err
 			}
 
-			err = c.service.DeleteMedia(httpReq.Context(), request)
-			return nil, err
+			if deleteMedia == nil {
+				return nil, errors.New("media cannot be deleted, changesets are not available")
+			}
+
+			return deleteMedia(httpReq.Context(), request)
 		})
 }
 
@@ -184,5 +200,9 @@ func init() {
 		log.Error().Err(err).Msg("Failed to instantiate media controller")
 	} else {
 		app.GetApp().ControllerRegistry.Register(controller)
+
+		transfer.RegisterMediaLookup(func(ctx context.Context, mediaIds []string) ([]types.MediaItem, error) {
+			return controller.service.GetMedia(ctx, []string{}, []string{}, mediaIds)
+		})
 	}
 }
@@ -3,6 +3,8 @@ package media
 import (
 	"context"
 	"fmt"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/egfanboy/mediapire-common/exceptions"
@@ -26,7 +28,6 @@ type MediaApi interface {
 	GetMediaByNodeId(ctx context.Context, mediaTypes []string, nodeId string) ([]types.MediaItem, error)
 	StreamMedia(ctx context.Context, nodeId string, mediaId string) ([]byte, error)
 	DownloadMediaAsync(ctx context.Context, request types.MediaDownloadRequest) (commonTypes.Transfer, error)
-	DeleteMedia(ctx context.Context, request types.MediaDeleteRequest) error
 	GetMediaArt(ctx context.Context, nodeId string, mediaId string) ([]byte, error)
 	GetMedia(ctx context.Context, mediaTypes []string, nodeIds []string, mediaIds []string) ([]types.MediaItem, error)
 	GetMediaPaginated(
@@ -36,7 +37,9 @@ type MediaApi interface {
 		filtering types.ApiFilteringParams,
 		pagination *pagination.ApiPaginationParams) (interface{}, error)
 	// Used by other internal services, not to be exposed via API
-	InternalUpdateMedia(ctx context.Context, changesetId string, request []types.Changeset) error
+	InternalPrepareUpdateMedia(ctx context.Context, request []types.Changeset) (*PreparedUpdate, error)
+	InternalDeleteMedia(ctx context.Context, changesetId string, inputs map[string][]string) error
+	InternalPreviewUpdateMedia(ctx context.Context, request []types.Changeset) ([]types.ChangesetPreviewItem, error)
 	InternalGetAllMediaFromNodes(ctx context.Context, nodeIds []string) ([]types.MediaItem, error)
 }
 
@@ -176,14 +179,9 @@ func (s *mediaService) StreamMedia(ctx context.Context, nodeId string, mediaId s
 	return b, err
 }
 
-func (s *mediaService) DeleteMedia(ctx context.Context, request types.MediaDeleteRequest) error {
-	log.Info().Msgf("Start: delete media")
-
-	inputs := make(map[string][]string)
-
-	for _, item := range request {
-		inputs[item.NodeId] = append(inputs[item.NodeId], item.MediaId)
-	}
+// media hosts do not acknowledge deletes, the changeset is confirmed from the media of the nodes once they are synced
+func (s *mediaService) InternalDeleteMedia(ctx context.Context, changesetId string, inputs map[string][]string) error {
+	log.Info().Msgf("Start: delete media for changeset %s", changesetId)
 
 	msg := messaging.DeleteMediaMessage{
 		MediaToDelete: inputs,
@@ -216,91 +214,178 @@ func (s *mediaService) GetMediaArt(ctx context.Context, nodeId string, mediaId s
 	return b, err
 }
 
-func (s *mediaService) InternalUpdateMedia(ctx context.Context, changeSetId string, changes []types.Changeset) error {
-	log.Info().Msg("Start: Update media")
-	result := messaging.UpdateMediaMessage{ChangesetId: changeSetId, Items: make(map[string][]messaging.UpdatedItem)}
+func (s *mediaService) getCachedClient(ctx context.Context, clients map[string]mhApi.MediaHostApi, nodeId string) (mhApi.MediaHostApi, error) {
+	if cachedClient, ok := clients[nodeId]; ok {
+		return cachedClient, nil
+	}
 
-	clients := make(map[string]mhApi.MediaHostApi)
-	var client mhApi.MediaHostApi
-	for _, change := range changes {
-		if cachedClient, ok := clients[change.NodeId]; ok {
-			client = cachedClient
-		} else {
-			node, err := s.nodeRepo.GetNode(ctx, change.NodeId)
-			if err != nil {
-				log.Err(err).Msgf("failed to get node %s", change.NodeId)
-				return err
-			}
+	node, err := s.nodeRepo.GetNode(ctx, nodeId)
+	if err != nil {
+		log.Err(err).Msgf("failed to get node %s", nodeId)
+		return nil, err
+	}
 
-			client = mhApi.NewClient(mhTypes.NewHttpHost(node.NodeHost, node.Port()))
+	client := mhApi.NewClient(mhTypes.NewHttpHost(node.NodeHost, node.Port()))
 
-			clients[change.NodeId] = client
-		}
+	clients[nodeId] = client
 
-		mediaItem, _, err := client.GetMediaByIdWithContent(ctx, change.MediaId)
-		if err != nil {
-			log.Err(err).Msgf("failed to get content for media %s on node %s", change.MediaId, change.NodeId)
-			return err
-		}
+	return client, nil
+}
 
-		builder := media_update.GetBuilder(mediaItem)
+func newUpdateBuilder(mediaItem mhTypes.MediaItemWithContent, change types.MediaItemChange) media_update.BaseUpdater {
+	builder := media_update.GetBuilder(mediaItem)
 
-		if change.Change.Name != "" {
-			builder.Name(change.Change.Name)
-		}
+	if change.Name != "" {
+		builder.Name(change.Name)
+	}
 
-		if change.Change.Artist != "" {
-			builder.Artist(change.Change.Artist)
-		}
+	if change.Artist != "" {
+		builder.Artist(change.Artist)
+	}
 
-		if change.Change.Album != "" {
-			builder.Album(change.Change.Album)
-		}
+	if change.Album != "" {
+		builder.Album(change.Album)
+	}
 
-		if change.Change.Comment != "" {
-			builder.Comment(change.Change.Comment)
-		}
+	if change.Comment != "" {
+		builder.Comment(change.Comment)
+	}
 
-		if change.Change.Genre != "" {
-			builder.Genre(change.Change.Genre)
-		}
+	if change.Genre != "" {
+		builder.Genre(change.Genre)
+	}
 
-		if change.Change.TrackIndex != 0 {
-			trackFormat := fmt.Sprintf("%d", change.Change.TrackIndex)
-			if change.Change.TrackOf != 0 {
-				// ffmpeg expects a format of 2/10 to represent track 2 of 10
-				trackFormat = fmt.Sprintf("%s/%d", trackFormat, change.Change.TrackOf)
-				builder.Track(trackFormat)
-			}
+	if change.TrackIndex != 0 {
+		trackFormat := fmt.Sprintf("%d", change.TrackIndex)
+		if change.TrackOf != 0 {
+			// ffmpeg expects a format of 2/10 to represent track 2 of 10
+			trackFormat = fmt.Sprintf("%s/%d", trackFormat, change.TrackOf)
 		}
 
-		// if no track of set the track to be empty
-		if change.Change.TrackOf == 0 {
-			builder.Track("")
-		}
+		builder.Track(trackFormat)
+	} else if change.TrackOf == 0 {
+		// if no track is set the track to be empty
+		builder.Track("")
+	}
 
-		if change.Change.Art != "" {
-			builder.Art(change.Change.Art)
+	if change.Art != "" {
+		builder.Art(change.Art)
+	}
+
+	for _, field := range change.Clear {
+		switch field {
+		case types.FieldName:
+			builder.Name("")
+		case types.FieldArtist:
+			builder.Artist("")
+		case types.FieldAlbum:
+			builder.Album("")
+		case types.FieldComment:
+			builder.Comment("")
+		case types.FieldGenre:
+			builder.Genre("")
+		case types.FieldArt:
+			builder.ClearArt()
 		}
+	}
 
-		newContent, err := media_update.UpdateMedia(builder)
+	return builder
+}
+
+func (s *mediaService) InternalPreviewUpdateMedia(ctx context.Context, changes []types.Changeset) ([]types.ChangesetPreviewItem, error) {
+	log.Info().Msg("Start: Preview update media")
+	result := make([]types.ChangesetPreviewItem, 0, len(changes))
+
+	workDir, err := os.MkdirTemp("", "changeset-preview-*")
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.RemoveAll(workDir)
+
+	artCache, err := newArtFileCache(workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make(map[string]mhApi.MediaHostApi)
+	for _, change := range changes {
+		item, err := s.previewItem(ctx, clients, artCache, change)
 		if err != nil {
-			log.Err(err).Msgf("Failed to update media item %s", change.MediaId)
-			return err
+			// the failure of an item is reported on the item so that the other items are still previewed
+			log.Err(err).Msgf("Failed to preview update of media item %s", change.MediaId)
+			item = types.ChangesetPreviewItem{
+				MediaItemMapping: change.MediaItemMapping,
+				Diff:             []types.MetadataFieldDiff{},
+				Warnings:         []string{},
+				Error:            err.Error(),
+			}
 		}
 
-		result.Items[change.NodeId] = append(result.Items[change.NodeId], messaging.UpdatedItem{MediaId: change.MediaId, Content: newContent})
+		result = append(result, item)
 	}
 
-	// send message
-	err := rabbitmq.PublishMessage(ctx, messaging.TopicUpdateMedia, result)
+	log.Info().Msg("End: Preview update media")
+	return result, nil
+}
+
+func (s *mediaService) previewItem(
+	ctx context.Context,
+	clients map[string]mhApi.MediaHostApi,
+	artCache *artFileCache,
+	change types.Changeset) (types.ChangesetPreviewItem, error) {
+	client, err := s.getCachedClient(ctx, clients, change.NodeId)
 	if err != nil {
-		log.Err(err).Msgf("Failed to publish message to topic %s", messaging.TopicUpdateMedia)
-		return err
+		return types.ChangesetPreviewItem{}, err
+	}
+
+	mediaItem, _, err := client.GetMediaByIdWithContent(ctx, change.MediaId)
+	if err != nil {
+		return types.ChangesetPreviewItem{}, fmt.Errorf("failed to get content of media %s on node %s: %w", change.MediaId, change.NodeId, err)
+	}
+
+	itemChange, err := artCache.resolve(ctx, change.Change)
+	if err != nil {
+		return types.ChangesetPreviewItem{}, err
+	}
+
+	preview, err := media_update.PreviewMedia(newUpdateBuilder(mediaItem, itemChange))
+	if err != nil {
+		return types.ChangesetPreviewItem{}, fmt.Errorf("failed to preview update of media %s on node %s: %w", change.MediaId, change.NodeId, err)
+	}
+
+	warnings := preview.Warnings
+	if warnings == nil {
+		warnings = make([]string, 0)
+	}
+
+	return types.ChangesetPreviewItem{MediaItemMapping: change.MediaItemMapping, Diff: diffTags(preview.Before, preview.After), Warnings: warnings}, nil
+}
+
+// returns the tags which differ between before and after, sorted by field name
+func diffTags(before, after map[string]string) []types.MetadataFieldDiff {
+	fields := make([]string, 0)
+
+	for k, v := range before {
+		if after[k] != v {
+			fields = append(fields, k)
+		}
+	}
+
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+
+	sort.Strings(fields)
+
+	result := make([]types.MetadataFieldDiff, len(fields))
+	for i, field := range fields {
+		result[i] = types.MetadataFieldDiff{Field: field, Before: before[field], After: after[field]}
 	}
 
-	log.Info().Msg("End: Update media")
-	return nil
+	return result
 }
 
 func (s *mediaService) GetMediaPaginated(
@@ -0,0 +1,332 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/egfanboy/mediapire-common/messaging"
+	"github.com/egfanboy/mediapire-manager/internal/app"
+	media_update "github.com/egfanboy/mediapire-manager/internal/media/update"
+	"github.com/egfanboy/mediapire-manager/internal/rabbitmq"
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+	mhApi "github.com/egfanboy/mediapire-media-host/pkg/api"
+	"github.com/rs/zerolog/log"
+)
+
+type preparedItem struct {
+	change types.Changeset
+	// path to the rewritten content on disk
+	path     string
+	size     int64
+	snapshot ItemSnapshot
+}
+
+// ItemSnapshot is the state of an item before it was rewritten
+type ItemSnapshot struct {
+	types.MediaItemMapping
+	Extension string
+	media_update.Snapshot
+}
+
+// ItemFailure is a media item that could not be rewritten
+type ItemFailure struct {
+	types.MediaItemMapping
+	Err error
+}
+
+// PreparedUpdate holds rewritten media on disk until it is published to the media hosts in chunks
+type PreparedUpdate struct {
+	workDir string
+	// chunks of items to send per node, each chunk is a single message
+	chunks   map[string][][]preparedItem
+	failures []ItemFailure
+}
+
+// Rewritten returns the items that were rewritten and will be published
+func (p *PreparedUpdate) Rewritten() []types.MediaItemMapping {
+	result := make([]types.MediaItemMapping, 0)
+
+	for _, chunks := range p.chunks {
+		for _, chunk := range chunks {
+			for _, item := range chunk {
+				result = append(result, item.change.MediaItemMapping)
+			}
+		}
+	}
+
+	return result
+}
+
+// Snapshots returns the state of every rewritten item before the update
+func (p *PreparedUpdate) Snapshots() []ItemSnapshot {
+	result := make([]ItemSnapshot, 0)
+
+	for _, chunks := range p.chunks {
+		for _, chunk := range chunks {
+			for _, item := range chunk {
+				result = append(result, item.snapshot)
+			}
+		}
+	}
+
+	return result
+}
+
+// Failures returns the items that could not be rewritten, they are not part of any chunk
+func (p *PreparedUpdate) Failures() []ItemFailure {
+	return p.failures
+}
+
+// Chunks returns the ids of the media of every update message that will be published to each node, in the order they are published
+func (p *PreparedUpdate) Chunks() map[string][][]string {
+	result := make(map[string][][]string)
+
+	for nodeId, chunks := range p.chunks {
+		for _, chunk := range chunks {
+			mediaIds := make([]string, len(chunk))
+			for i, item := range chunk {
+				mediaIds[i] = item.change.MediaId
+			}
+
+			result[nodeId] = append(result[nodeId], mediaIds)
+		}
+	}
+
+	return result
+}
+
+// Publish sends every chunk as its own update message, only loading the content of one chunk at a time
+func (p *PreparedUpdate) Publish(ctx context.Context, changesetId string) error {
+	for nodeId, chunks := range p.chunks {
+		for i, chunk := range chunks {
+			// stop sending chunks once the changeset is cancelled
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			msg := messaging.UpdateMediaMessage{ChangesetId: changesetId, Items: make(map[string][]messaging.UpdatedItem)}
+
+			for _, item := range chunk {
+				content, err := os.ReadFile(item.path)
+				if err != nil {
+					return err
+				}
+
+				msg.Items[nodeId] = append(msg.Items[nodeId], messaging.UpdatedItem{MediaId: item.change.MediaId, Content: content})
+			}
+
+			err := rabbitmq.PublishMessage(ctx, messaging.TopicUpdateMedia, msg)
+			if err != nil {
+				log.Err(err).Msgf("Failed to publish chunk %d of %d for node %s to topic %s", i+1, len(chunks), nodeId, messaging.TopicUpdateMedia)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Cleanup removes the rewritten content from disk
+func (p *PreparedUpdate) Cleanup() {
+	err := os.RemoveAll(p.workDir)
+	if err != nil {
+		log.Err(err).Msgf("failed to cleanup prepared update directory %s", p.workDir)
+	}
+}
+
+// splits the items of every node in chunks that fit in a message, items that cannot fit in a message on their own are returned as failures
+func chunkItems(items []preparedItem, maxMessageSize int64) (map[string][][]preparedItem, []ItemFailure) {
+	result := make(map[string][][]preparedItem)
+	chunkSizes := make(map[string]int64)
+	failures := make([]ItemFailure, 0)
+
+	for _, item := range items {
+		nodeId := item.change.NodeId
+		// content is base64 encoded in the message
+		encodedSize := item.size * 4 / 3
+
+		if encodedSize > maxMessageSize {
+			failures = append(failures, ItemFailure{
+				MediaItemMapping: item.change.MediaItemMapping,
+				Err:              fmt.Errorf("media is larger than the max message size of %d MB once encoded", maxMessageSize>>20),
+			})
+
+			continue
+		}
+
+		nodeChunks := result[nodeId]
+		if len(nodeChunks) == 0 || chunkSizes[nodeId]+encodedSize > maxMessageSize {
+			result[nodeId] = append(nodeChunks, []preparedItem{item})
+			chunkSizes[nodeId] = encodedSize
+
+			continue
+		}
+
+		nodeChunks[len(nodeChunks)-1] = append(nodeChunks[len(nodeChunks)-1], item)
+		chunkSizes[nodeId] += encodedSize
+	}
+
+	return result, failures
+}
+
+type updateWorkerPool struct {
+	service     *mediaService
+	concurrency int
+	workDir     string
+	art         *artFileCache
+
+	clientsMu sync.Mutex
+	clients   map[string]mhApi.MediaHostApi
+}
+
+func (w *updateWorkerPool) getClient(ctx context.Context, nodeId string) (mhApi.MediaHostApi, error) {
+	w.clientsMu.Lock()
+	defer w.clientsMu.Unlock()
+
+	return w.service.getCachedClient(ctx, w.clients, nodeId)
+}
+
+func (w *updateWorkerPool) rewrite(ctx context.Context, change types.Changeset) (preparedItem, error) {
+	client, err := w.getClient(ctx, change.NodeId)
+	if err != nil {
+		return preparedItem{}, err
+	}
+
+	mediaItem, _, err := client.GetMediaByIdWithContent(ctx, change.MediaId)
+	if err != nil {
+		log.Err(err).Msgf("failed to get content for media %s on node %s", change.MediaId, change.NodeId)
+		return preparedItem{}, err
+	}
+
+	itemChange, err := w.art.resolve(ctx, change.Change)
+	if err != nil {
+		log.Err(err).Msgf("Failed to get art for media item %s", change.MediaId)
+		return preparedItem{}, err
+	}
+
+	snapshot, err := media_update.TakeSnapshot(mediaItem)
+	if err != nil {
+		log.Err(err).Msgf("Failed to snapshot media item %s", change.MediaId)
+		return preparedItem{}, err
+	}
+
+	destination := path.Join(w.workDir, fmt.Sprintf("%s-%s.%s", change.NodeId, change.MediaId, mediaItem.Extension))
+
+	err = media_update.UpdateMedia(newUpdateBuilder(mediaItem, itemChange), destination)
+	if err != nil {
+		log.Err(err).Msgf("Failed to update media item %s", change.MediaId)
+		return preparedItem{}, err
+	}
+
+	info, err := os.Stat(destination)
+	if err != nil {
+		return preparedItem{}, err
+	}
+
+	return preparedItem{
+		change:   change,
+		path:     destination,
+		size:     info.Size(),
+		snapshot: ItemSnapshot{MediaItemMapping: change.MediaItemMapping, Extension: mediaItem.Extension, Snapshot: snapshot},
+	}, nil
+}
+
+// rewrites every change with at most concurrency items in flight, an item failing does not stop the others
+func (w *updateWorkerPool) Run(ctx context.Context, changes []types.Changeset) ([]preparedItem, []ItemFailure, error) {
+	jobs := make(chan int)
+	results := make([]*preparedItem, len(changes))
+	errs := make([]error, len(changes))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < w.concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for index := range jobs {
+				item, err := w.rewrite(ctx, changes[index])
+				if err != nil {
+					errs[index] = err
+					continue
+				}
+
+				results[index] = &item
+			}
+		}()
+	}
+
+	for i := range changes {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	items := make([]preparedItem, 0)
+	failures := make([]ItemFailure, 0)
+
+	for i, change := range changes {
+		if errs[i] != nil {
+			failures = append(failures, ItemFailure{MediaItemMapping: change.MediaItemMapping, Err: errs[i]})
+			continue
+		}
+
+		items = append(items, *results[i])
+	}
+
+	return items, failures, nil
+}
+
+func (s *mediaService) InternalPrepareUpdateMedia(ctx context.Context, changes []types.Changeset) (*PreparedUpdate, error) {
+	log.Info().Msg("Start: Prepare update media")
+	cfg := app.GetApp().Config.Changesets
+
+	workDir, err := os.MkdirTemp("", "changeset-*")
+	if err != nil {
+		return nil, err
+	}
+
+	artCache, err := newArtFileCache(workDir)
+	if err != nil {
+		os.RemoveAll(workDir)
+		return nil, err
+	}
+
+	pool := &updateWorkerPool{
+		service:     s,
+		concurrency: cfg.Concurrency,
+		workDir:     workDir,
+		art:         artCache,
+		clients:     make(map[string]mhApi.MediaHostApi),
+	}
+
+	items, failures, err := pool.Run(ctx, changes)
+	if err != nil {
+		os.RemoveAll(workDir)
+		return nil, err
+	}
+
+	chunks, oversized := chunkItems(items, int64(cfg.MaxMessageSizeMB)<<20)
+
+	log.Info().Msg("End: Prepare update media")
+	return &PreparedUpdate{
+		workDir:  workDir,
+		chunks:   chunks,
+		failures: append(failures, oversized...),
+	}, nil
+}
@@ -33,52 +33,55 @@ func (w *errorWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
-func cleanupUpdate(builder UpdateBuilder) {
-	inputPath := getInputPath(builder.Item())
-
-	err := os.Remove(inputPath)
-	if err != nil && !os.IsNotExist(err) {
-		log.Err(err).Msgf("failed to cleanup temporary input file for update %s", builder.Item().Id)
-	}
-
-	outputPath := getOutputPath(builder.Item())
-	err = os.Remove(outputPath)
-	if err != nil && !os.IsNotExist(err) {
-		log.Err(err).Msgf("failed to cleanup temporary output file for update %s", builder.Item().Id)
+func cleanupUpdate(builder UpdateBuilder, workDir string) {
+	err := os.RemoveAll(workDir)
+	if err != nil {
+		log.Err(err).Msgf("failed to cleanup temporary files for update %s", builder.Item().Id)
 	}
 }
 
-func getOutputPath(item mhTypes.MediaItemWithContent) string {
-	return path.Join("/tmp", fmt.Sprintf("%s-temp-out.%s", item.Id, item.Extension))
+func getOutputPath(workDir string, item mhTypes.MediaItemWithContent) string {
+	return path.Join(workDir, fmt.Sprintf("%s-temp-out.%s", item.Id, item.Extension))
 }
 
-func getInputPath(item mhTypes.MediaItemWithContent) string {
-	return path.Join("/tmp", fmt.Sprintf("%s-temp-in.%s", item.Id, item.Extension))
+func getInputPath(workDir string, item mhTypes.MediaItemWithContent) string {
+	return path.Join(workDir, fmt.Sprintf("%s-temp-in.%s", item.Id, item.Extension))
 }
 
-func defaultGetInputsImpl(u *baseMediaUpdater) []*ffmpeg_go.Stream {
-	result := []*ffmpeg_go.Stream{ffmpeg_go.Input(getInputPath(u.media))}
+func defaultGetInputsImpl(u *baseMediaUpdater, inputPath string) []*ffmpeg_go.Stream {
+	result := []*ffmpeg_go.Stream{ffmpeg_go.Input(inputPath)}
 	if u.imagePath != nil {
 		result = append(result, ffmpeg_go.Input(*u.imagePath))
 	}
 	return result
 }
 
-func mp3GetInputsImpl(u *baseMediaUpdater) []*ffmpeg_go.Stream {
-	mp3FileStream := ffmpeg_go.Input(getInputPath(u.media))
+func mp3GetInputsImpl(u *baseMediaUpdater, inputPath string) []*ffmpeg_go.Stream {
+	mp3FileStream := ffmpeg_go.Input(inputPath)
 
 	// If we want to change the image we need to take ONLY the audio portion of the mp3 file
 	// if we don't ffmpeg will just append another album art stream in the metadata
 	if u.imagePath != nil {
 		return []*ffmpeg_go.Stream{mp3FileStream.Audio(), ffmpeg_go.Input(*u.imagePath)}
 	}
+
+	// dropping the video stream removes the album cover
+	if u.clearArt {
+		return []*ffmpeg_go.Stream{mp3FileStream.Audio()}
+	}
 	return []*ffmpeg_go.Stream{mp3FileStream}
 }
 
 type UpdateBuilder interface {
-	GetInputs() []*ffmpeg_go.Stream
+	GetInputs(inputPath string) []*ffmpeg_go.Stream
 	BuildArgs() (ffmpeg_go.KwArgs, error)
 	Item() types.MediaItemWithContent
+	// Warnings returns the requested changes that cannot be applied to the item
+	Warnings() []string
+	// Tags returns the tags the update will write to the item
+	Tags() map[string]string
+	// HasArt returns whether the update will write the album cover to the item
+	HasArt() bool
 }
 
 type BaseUpdater interface {
@@ -91,6 +94,7 @@ type BaseUpdater interface {
 	Genre(genre string) BaseUpdater
 	Track(track string) BaseUpdater
 	Art(imagePath string) BaseUpdater
+	ClearArt() BaseUpdater
 }
 
 type baseMediaUpdater struct {
@@ -98,58 +102,75 @@ type baseMediaUpdater struct {
 	media        types.MediaItemWithContent
 
 	imagePath *string
+	clearArt  bool
 	metadata  []string
 	// ffmpeg takes metadata for a video stream
 	// ie: -metadata:s:v. Used in MP3 files to set the cover art by taking the input video stream as the source
 	videoMetadata []string
+	warnings      []string
+	// tags requested by the update, used to verify the output
+	tags map[string]string
 
 	// custom functions based on media type
-	getInputStreamsImpl func(self *baseMediaUpdater) []*ffmpeg_go.Stream
+	getInputStreamsImpl func(self *baseMediaUpdater, inputPath string) []*ffmpeg_go.Stream
 }
 
 func (u *baseMediaUpdater) Name(name string) BaseUpdater {
-	u.metadata = append(u.metadata, fmt.Sprintf(`title=%s`, name))
+	u.setTag("title", name)
 
 	return u
 }
 
 func (u *baseMediaUpdater) Artist(artist string) BaseUpdater {
-	u.metadata = append(u.metadata, fmt.Sprintf(`artist=%s`, artist))
+	u.setTag("artist", artist)
 
 	return u
 }
 
 func (u *baseMediaUpdater) Album(album string) BaseUpdater {
-	u.metadata = append(u.metadata, fmt.Sprintf(`album=%s`, album))
+	u.setTag("album", album)
 
 	return u
 }
 
 func (u *baseMediaUpdater) Comment(comment string) BaseUpdater {
-	u.metadata = append(u.metadata, fmt.Sprintf(`comment=%s`, comment))
+	u.setTag("comment", comment)
 
 	return u
 }
 
 func (u *baseMediaUpdater) Genre(genre string) BaseUpdater {
-	u.metadata = append(u.metadata, fmt.Sprintf(`genre=%s`, genre))
+	u.setTag("genre", genre)
 
 	return u
 }
 
+// SupportsTrack returns whether the track index can be written to files with the extension
+func SupportsTrack(extension string) bool {
+	return extension == "mp3"
+}
+
+// SupportsArt returns whether the album cover can be written to files with the extension
+func SupportsArt(extension string) bool {
+	return extension == "mp3"
+}
+
 func (u *baseMediaUpdater) Track(track string) BaseUpdater {
-	if u.media.Extension != "mp3" {
-		log.Warn().Msgf("Item %s does not support updating the track index", u.media.Id)
+	if !SupportsTrack(u.media.Extension) {
+		// clearing the track on an item that does not support it is a no-op
+		if track != "" {
+			u.warn("Item %s does not support updating the track index", u.media.Id)
+		}
 	} else {
-		u.metadata = append(u.metadata, fmt.Sprintf("track=%s", track))
+		u.setTag("track", track)
 	}
 
 	return u
 }
 
 func (u *baseMediaUpdater) Art(imagePath string) BaseUpdater {
-	if u.media.Extension != "mp3" {
-		log.Warn().Msgf("Item %s does not support updating the album cover", u.media.Id)
+	if !SupportsArt(u.media.Extension) {
+		u.warn("Item %s does not support updating the album cover", u.media.Id)
 	} else {
 		u.imagePath = &imagePath
 		u.videoMetadata = append(u.videoMetadata, `title=Album cover`)
@@ -160,6 +181,41 @@ func (u *baseMediaUpdater) Art(imagePath string) BaseUpdater {
 	return u
 }
 
+func (u *baseMediaUpdater) ClearArt() BaseUpdater {
+	if !SupportsArt(u.media.Extension) {
+		u.warn("Item %s does not support removing the album cover", u.media.Id)
+	} else {
+		u.clearArt = true
+	}
+
+	return u
+}
+
+func (u *baseMediaUpdater) setTag(key, value string) {
+	u.metadata = append(u.metadata, fmt.Sprintf("%s=%s", key, value))
+
+	u.tags[key] = value
+}
+
+func (u *baseMediaUpdater) warn(format string, v ...interface{}) {
+	warning := fmt.Sprintf(format, v...)
+	log.Warn().Msg(warning)
+
+	u.warnings = append(u.warnings, warning)
+}
+
+func (u *baseMediaUpdater) Warnings() []string {
+	return u.warnings
+}
+
+func (u *baseMediaUpdater) Tags() map[string]string {
+	return u.tags
+}
+
+func (u *baseMediaUpdater) HasArt() bool {
+	return u.imagePath != nil
+}
+
 func (u *baseMediaUpdater) BuildArgs() (ffmpeg_go.KwArgs, error) {
 	ffmpegArgs := ffmpeg_go.KwArgs{}
 
@@ -182,43 +238,91 @@ func (u *baseMediaUpdater) Item() mhTypes.MediaItemWithContent {
 	return u.media
 }
 
-func (u *baseMediaUpdater) GetInputs() []*ffmpeg_go.Stream {
-	return u.getInputStreamsImpl(u)
+func (u *baseMediaUpdater) GetInputs(inputPath string) []*ffmpeg_go.Stream {
+	return u.getInputStreamsImpl(u, inputPath)
 }
 
-func UpdateMedia(builder UpdateBuilder) ([]byte, error) {
-	inputPath := getInputPath(builder.Item())
+// runs ffmpeg for the builder and hands the input and output files to fn before they are cleaned up
+func runUpdate(builder UpdateBuilder, fn func(inputPath, outputPath string) error) error {
+	// every update gets its own directory so concurrent updates of the same item do not collide
+	workDir, err := os.MkdirTemp("", fmt.Sprintf("%s-update-*", builder.Item().Id))
+	if err != nil {
+		return err
+	}
+
+	defer cleanupUpdate(builder, workDir)
+
+	inputPath := getInputPath(workDir, builder.Item())
 
 	// ffmpeg needs the input to be actual files so write a temp file with the media content
-	err := os.WriteFile(inputPath, builder.Item().Content, 0666)
+	err = os.WriteFile(inputPath, builder.Item().Content, 0666)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	defer cleanupUpdate(builder)
-
 	ffmpegArgs, err := builder.BuildArgs()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	outputPath := getOutputPath(builder.Item())
+	outputPath := getOutputPath(workDir, builder.Item())
 	w := &errorWriter{}
-	err = ffmpeg_go.Output(builder.GetInputs(), outputPath, ffmpegArgs).
+	err = ffmpeg_go.Output(builder.GetInputs(inputPath), outputPath, ffmpegArgs).
 		OverWriteOutput().
 		WithErrorOutput(w).
 		Silent(true).
 		Run()
 	if err != nil {
-		return nil, fmt.Errorf("err: %w. %s", err, string(w.lastWrite))
+		return fmt.Errorf("err: %w. %s", err, string(w.lastWrite))
 	}
 
-	return os.ReadFile(outputPath)
+	return fn(inputPath, outputPath)
+}
+
+// UpdateMedia runs the update for the builder and moves the verified result to destination.
+// destination must be on the same filesystem as the temporary directory.
+func UpdateMedia(builder UpdateBuilder, destination string) error {
+	return runUpdate(builder, func(inputPath, outputPath string) error {
+		err := verifyUpdate(builder, inputPath, outputPath)
+		if err != nil {
+			return err
+		}
+
+		return os.Rename(outputPath, destination)
+	})
+}
+
+type Preview struct {
+	Before   map[string]string
+	After    map[string]string
+	Warnings []string
+}
+
+// PreviewMedia runs the update for the builder and returns the tags of the item before and after the update without keeping the result
+func PreviewMedia(builder UpdateBuilder) (result Preview, err error) {
+	err = runUpdate(builder, func(inputPath, outputPath string) error {
+		before, err := probeFile(inputPath)
+		if err != nil {
+			return err
+		}
+
+		after, err := probeFile(outputPath)
+		if err != nil {
+			return err
+		}
+
+		result = Preview{Before: before.Tags(), After: after.Tags(), Warnings: builder.Warnings()}
+
+		return nil
+	})
+
+	return
 }
 
 func GetBuilder(media types.MediaItemWithContent) BaseUpdater {
 	updater := &baseMediaUpdater{
 		media: media,
+		tags:  make(map[string]string),
 		baseMetadata: map[string]interface{}{
 			keyC: "copy",
 		},
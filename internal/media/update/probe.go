@@ -0,0 +1,76 @@
+package media_update
+
+import (
+	"encoding/json"
+	"strings"
+
+	ffmpeg_go "github.com/u2takey/ffmpeg-go"
+)
+
+const (
+	codecTypeAudio = "audio"
+	// album covers are exposed as a video stream
+	codecTypeVideo = "video"
+)
+
+type probeStream struct {
+	CodecType string            `json:"codec_type"`
+	CodecName string            `json:"codec_name"`
+	Duration  string            `json:"duration"`
+	Tags      map[string]string `json:"tags"`
+}
+
+type probeFormat struct {
+	Duration string            `json:"duration"`
+	Tags     map[string]string `json:"tags"`
+}
+
+type probeResult struct {
+	Streams []probeStream `json:"streams"`
+	Format  probeFormat   `json:"format"`
+}
+
+// Tags returns the tags of the file with lowercased keys since the casing depends on the format (ie: TITLE for flac).
+// Ogg files keep their tags on the audio stream, container level tags take precedence over them
+func (p *probeResult) Tags() map[string]string {
+	result := make(map[string]string)
+
+	if stream := p.AudioStream(); stream != nil {
+		for k, v := range stream.Tags {
+			result[strings.ToLower(k)] = v
+		}
+	}
+
+	for k, v := range p.Format.Tags {
+		result[strings.ToLower(k)] = v
+	}
+
+	return result
+}
+
+// AudioStream returns the first audio stream of the file, nil if there is none
+func (p *probeResult) AudioStream() *probeStream {
+	for i := range p.Streams {
+		if p.Streams[i].CodecType == codecTypeAudio {
+			return &p.Streams[i]
+		}
+	}
+
+	return nil
+}
+
+func probeFile(filePath string) (*probeResult, error) {
+	output, err := ffmpeg_go.Probe(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &probeResult{}
+
+	err = json.Unmarshal([]byte(output), result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
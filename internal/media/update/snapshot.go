@@ -0,0 +1,84 @@
+package media_update
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	mhTypes "github.com/egfanboy/mediapire-media-host/pkg/types"
+	ffmpeg_go "github.com/u2takey/ffmpeg-go"
+)
+
+// extension of the extracted album cover for the codecs used by embedded art
+var artExtensions = map[string]string{
+	"mjpeg": "jpg",
+	"png":   "png",
+	"bmp":   "bmp",
+}
+
+// Snapshot is the state of the tags and album cover of an item, used to revert an update
+type Snapshot struct {
+	Tags map[string]string
+	// empty when the item has no album cover
+	Art []byte
+}
+
+// TakeSnapshot reads the current tags and embedded album cover of the item
+func TakeSnapshot(item mhTypes.MediaItemWithContent) (result Snapshot, err error) {
+	workDir, err := os.MkdirTemp("", fmt.Sprintf("%s-snapshot-*", item.Id))
+	if err != nil {
+		return
+	}
+
+	defer os.RemoveAll(workDir)
+
+	inputPath := getInputPath(workDir, item)
+
+	err = os.WriteFile(inputPath, item.Content, 0666)
+	if err != nil {
+		return
+	}
+
+	probe, err := probeFile(inputPath)
+	if err != nil {
+		return
+	}
+
+	result.Tags = probe.Tags()
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType != codecTypeVideo {
+			continue
+		}
+
+		// copy the cover as is without the audio
+		args := ffmpeg_go.KwArgs{"an": "", "c:v": "copy", "frames:v": 1}
+
+		extension, ok := artExtensions[stream.CodecName]
+		if !ok {
+			// keep the raw bytes of covers using other codecs so the item can still be reverted
+			extension = "bin"
+			args["f"] = "data"
+		}
+
+		artPath := path.Join(workDir, fmt.Sprintf("%s-art.%s", item.Id, extension))
+
+		w := &errorWriter{}
+		err = ffmpeg_go.Input(inputPath).
+			Output(artPath, args).
+			OverWriteOutput().
+			WithErrorOutput(w).
+			Silent(true).
+			Run()
+		if err != nil {
+			err = fmt.Errorf("failed to extract album cover of media %s: %w. %s", item.Id, err, string(w.lastWrite))
+			return
+		}
+
+		result.Art, err = os.ReadFile(artPath)
+
+		break
+	}
+
+	return
+}
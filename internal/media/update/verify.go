@@ -0,0 +1,115 @@
+package media_update
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	// the audio is copied as is, allow for rounding differences in the container
+	durationToleranceSeconds = 0.5
+)
+
+type VerificationError struct {
+	MediaId string
+	Reason  string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("verification of updated media %s failed: %s", e.MediaId, e.Reason)
+}
+
+func parseDuration(stream *probeStream, format probeFormat) (float64, error) {
+	duration := stream.Duration
+	// some containers only report the duration on the format
+	if duration == "" {
+		duration = format.Duration
+	}
+
+	return strconv.ParseFloat(duration, 64)
+}
+
+// verifyUpdate probes the output of an update and ensures the audio stream is intact and the requested tags were written
+func verifyUpdate(builder UpdateBuilder, inputPath, outputPath string) error {
+	mediaId := builder.Item().Id
+
+	input, err := probeFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to probe original media %s: %w", mediaId, err)
+	}
+
+	output, err := probeFile(outputPath)
+	if err != nil {
+		return &VerificationError{MediaId: mediaId, Reason: fmt.Sprintf("output could not be probed: %s", err.Error())}
+	}
+
+	inputAudio, outputAudio := input.AudioStream(), output.AudioStream()
+	if inputAudio == nil {
+		return fmt.Errorf("original media %s does not contain an audio stream", mediaId)
+	}
+
+	if outputAudio == nil {
+		return &VerificationError{MediaId: mediaId, Reason: "output does not contain an audio stream"}
+	}
+
+	if inputAudio.CodecName != outputAudio.CodecName {
+		return &VerificationError{
+			MediaId: mediaId,
+			Reason:  fmt.Sprintf("audio codec changed from %s to %s", inputAudio.CodecName, outputAudio.CodecName),
+		}
+	}
+
+	inputDuration, err := parseDuration(inputAudio, input.Format)
+	if err != nil {
+		return fmt.Errorf("failed to read duration of original media %s: %w", mediaId, err)
+	}
+
+	outputDuration, err := parseDuration(outputAudio, output.Format)
+	if err != nil {
+		return &VerificationError{MediaId: mediaId, Reason: fmt.Sprintf("output duration could not be read: %s", err.Error())}
+	}
+
+	if math.Abs(inputDuration-outputDuration) > durationToleranceSeconds {
+		return &VerificationError{
+			MediaId: mediaId,
+			Reason:  fmt.Sprintf("audio duration changed from %.2fs to %.2fs", inputDuration, outputDuration),
+		}
+	}
+
+	outputTags := output.Tags()
+	for key, expected := range builder.Tags() {
+		// an empty value clears the tag, nothing to verify
+		if expected == "" {
+			continue
+		}
+
+		actual, ok := outputTags[key]
+		if !ok {
+			return &VerificationError{MediaId: mediaId, Reason: fmt.Sprintf("tag %s is missing from the output", key)}
+		}
+
+		if actual != expected {
+			return &VerificationError{
+				MediaId: mediaId,
+				Reason:  fmt.Sprintf("tag %s was written as %q instead of %q", key, actual, expected),
+			}
+		}
+	}
+
+	if builder.HasArt() {
+		hasArt := false
+		for _, stream := range output.Streams {
+			if stream.CodecType == codecTypeVideo {
+				hasArt = true
+				break
+			}
+		}
+
+		if !hasArt {
+			return &VerificationError{MediaId: mediaId, Reason: "album cover is missing from the output"}
+		}
+	}
+
+	return nil
+}
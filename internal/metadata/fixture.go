@@ -0,0 +1,80 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+)
+
+// allowed difference in seconds between the duration of the query and the fixture
+const fixtureDurationToleranceSeconds = 3
+
+type fixtureEntry struct {
+	Title      string                  `json:"title"`
+	Artist     string                  `json:"artist"`
+	Album      string                  `json:"album"`
+	Duration   float64                 `json:"duration"`
+	Candidates []types.MediaItemChange `json:"candidates"`
+}
+
+func (e fixtureEntry) matches(query Query) bool {
+	if e.Title != "" && !strings.EqualFold(e.Title, query.Title) {
+		return false
+	}
+
+	if e.Artist != "" && !strings.EqualFold(e.Artist, query.Artist) {
+		return false
+	}
+
+	if e.Album != "" && !strings.EqualFold(e.Album, query.Album) {
+		return false
+	}
+
+	if e.Duration > 0 && query.Duration > 0 && math.Abs(e.Duration-query.Duration) > fixtureDurationToleranceSeconds {
+		return false
+	}
+
+	return true
+}
+
+// fixtureProvider answers lookups from a local JSON file, used to test tagging without reaching an external service
+type fixtureProvider struct {
+	entries []fixtureEntry
+}
+
+func (p *fixtureProvider) Lookup(ctx context.Context, query Query) ([]types.MediaItemChange, error) {
+	result := make([]types.MediaItemChange, 0)
+
+	for _, entry := range p.entries {
+		if entry.matches(query) {
+			result = append(result, entry.Candidates...)
+		}
+	}
+
+	return result, nil
+}
+
+func newFixtureProvider(fixturePath string) (Provider, error) {
+	if fixturePath == "" {
+		return nil, errors.New("fixture metadata provider requires a fixture path")
+	}
+
+	content, err := os.ReadFile(fixturePath)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &fixtureProvider{}
+
+	err = json.Unmarshal(content, &p.entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
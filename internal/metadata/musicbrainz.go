@@ -0,0 +1,209 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+)
+
+const (
+	musicBrainzSearchLimit = 5
+	// durations reported by musicbrainz are rarely an exact match of the file
+	musicBrainzDurationToleranceMs = 3000
+	musicBrainzUserAgent           = "mediapire-manager ( https://github.com/egfanboy/mediapire-manager )"
+	// musicbrainz blocks clients sending more than a request per second
+	musicBrainzRequestInterval = time.Second
+)
+
+// spaces out requests to musicbrainz, a provider is created for every suggestion request so the limiter is shared
+var musicBrainzLimiter = &rateLimiter{interval: musicBrainzRequestInterval}
+
+type rateLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+// waits until the next request is allowed or the context is done
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+
+	l.next = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type musicBrainzRecording struct {
+	Title        string `json:"title"`
+	ArtistCredit []struct {
+		Name string `json:"name"`
+	} `json:"artist-credit"`
+	Releases []struct {
+		Title string `json:"title"`
+		Media []struct {
+			TrackCount int `json:"track-count"`
+			Track      []struct {
+				Number string `json:"number"`
+			} `json:"track"`
+		} `json:"media"`
+	} `json:"releases"`
+	Tags []struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	} `json:"tags"`
+}
+
+type musicBrainzSearchResponse struct {
+	Recordings []musicBrainzRecording `json:"recordings"`
+}
+
+func (r musicBrainzRecording) toChange() types.MediaItemChange {
+	change := types.MediaItemChange{Name: r.Title}
+
+	artists := make([]string, len(r.ArtistCredit))
+	for i, credit := range r.ArtistCredit {
+		artists[i] = credit.Name
+	}
+
+	change.Artist = strings.Join(artists, ", ")
+
+	if len(r.Releases) > 0 {
+		release := r.Releases[0]
+		change.Album = release.Title
+
+		if len(release.Media) > 0 {
+			media := release.Media[0]
+			change.TrackOf = media.TrackCount
+
+			if len(media.Track) > 0 {
+				change.TrackIndex, _ = strconv.Atoi(media.Track[0].Number)
+			}
+		}
+	}
+
+	// use the most voted tag as the genre
+	topCount := 0
+	for _, tag := range r.Tags {
+		if tag.Count > topCount {
+			topCount = tag.Count
+			change.Genre = tag.Name
+		}
+	}
+
+	return change
+}
+
+type musicBrainzProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+func escapeLucene(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+}
+
+func (p *musicBrainzProvider) buildQuery(query Query) string {
+	clauses := make([]string, 0)
+
+	if query.Title != "" {
+		clauses = append(clauses, fmt.Sprintf(`recording:"%s"`, escapeLucene(query.Title)))
+	}
+
+	if query.Artist != "" {
+		clauses = append(clauses, fmt.Sprintf(`artist:"%s"`, escapeLucene(query.Artist)))
+	}
+
+	if query.Album != "" {
+		clauses = append(clauses, fmt.Sprintf(`release:"%s"`, escapeLucene(query.Album)))
+	}
+
+	if query.Duration > 0 {
+		durationMs := int(query.Duration * 1000)
+		clauses = append(
+			clauses,
+			fmt.Sprintf("dur:[%d TO %d]", durationMs-musicBrainzDurationToleranceMs, durationMs+musicBrainzDurationToleranceMs),
+		)
+	}
+
+	return strings.Join(clauses, " AND ")
+}
+
+func (p *musicBrainzProvider) Lookup(ctx context.Context, query Query) ([]types.MediaItemChange, error) {
+	luceneQuery := p.buildQuery(query)
+	if luceneQuery == "" {
+		return []types.MediaItemChange{}, nil
+	}
+
+	params := url.Values{}
+	params.Set("query", luceneQuery)
+	params.Set("fmt", "json")
+	params.Set("limit", strconv.Itoa(musicBrainzSearchLimit))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/ws/2/recording?%s", p.baseURL, params.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = musicBrainzLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// musicbrainz rejects requests without a meaningful user agent
+	request.Header.Set("User-Agent", musicBrainzUserAgent)
+	request.Header.Set("Accept", "application/json")
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata lookup failed with status %d", response.StatusCode)
+	}
+
+	var body musicBrainzSearchResponse
+
+	err = json.NewDecoder(response.Body).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]types.MediaItemChange, len(body.Recordings))
+	for i, recording := range body.Recordings {
+		result[i] = recording.toChange()
+	}
+
+	return result, nil
+}
+
+func newMusicBrainzProvider(baseURL string) Provider {
+	return &musicBrainzProvider{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
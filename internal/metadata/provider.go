@@ -0,0 +1,42 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/egfanboy/mediapire-manager/internal/app"
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+)
+
+const (
+	ProviderMusicBrainz = "musicbrainz"
+	ProviderFixture     = "fixture"
+)
+
+type Query struct {
+	Title  string
+	Artist string
+	Album  string
+	// duration of the item in seconds, 0 if unknown
+	Duration float64
+}
+
+// Provider looks up the tags of an item from an external source
+type Provider interface {
+	// Lookup returns candidate tags for the query, the best match first
+	Lookup(ctx context.Context, query Query) ([]types.MediaItemChange, error)
+}
+
+// NewProvider creates the provider configured for the app
+func NewProvider() (Provider, error) {
+	cfg := app.GetApp().Config.Metadata
+
+	switch cfg.Provider {
+	case ProviderMusicBrainz:
+		return newMusicBrainzProvider(cfg.BaseURL), nil
+	case ProviderFixture:
+		return newFixtureProvider(cfg.FixturePath)
+	default:
+		return nil, fmt.Errorf("unknown metadata provider %s", cfg.Provider)
+	}
+}
@@ -6,10 +6,16 @@ import (
 	"time"
 
 	"github.com/egfanboy/mediapire-manager/internal/app"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// MaxUpdateAttempts is how many times a document is read and updated again when it was modified concurrently
+const MaxUpdateAttempts = 10
+
 var mongoClient *mongo.Client
 
 var mediapireDB *mongo.Database
@@ -48,3 +54,21 @@ func NewCollection(collection string) (*mongo.Collection, error) {
 	// TODO: may need to handle db being nil
 	return mediapireDB.Collection(collection), nil
 }
+
+func NewBucket(bucket string) (*gridfs.Bucket, error) {
+	if mongoClient == nil {
+		return nil, errNoClientError
+	}
+
+	return gridfs.NewBucket(mediapireDB, options.GridFSBucket().SetName(bucket))
+}
+
+// VersionFilter matches the document only while it still has the version it was read with
+func VersionFilter(id primitive.ObjectID, version int) bson.M {
+	// documents saved before versions were tracked do not have the field
+	if version == 0 {
+		return bson.M{"_id": id, "version": bson.M{"$in": bson.A{0, nil}}}
+	}
+
+	return bson.M{"_id": id, "version": version}
+}
@@ -3,10 +3,9 @@ package transfer
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"path"
-	"time"
 
 	"github.com/egfanboy/mediapire-common/messaging"
 	"github.com/egfanboy/mediapire-manager/internal/app"
@@ -17,6 +16,17 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// tells the media hosts to abort a transfer. It is not part of the common messaging topics yet, the media hosts consume it once
+// they add support for it and keep preparing the content of a cancelled transfer until then
+const topicTransferCancel = "transfer-cancel"
+
+type transferCancelMessage struct {
+	TransferId string
+	TargetId   string
+	// media of the transfer keyed by node id, the nodes abort preparing them
+	Inputs map[string][]string
+}
+
 func handleTransferUpdateMessage(ctx context.Context, msg amqp091.Delivery) {
 	var updateMsg messaging.TransferUpdateMessage
 
@@ -50,6 +60,12 @@ func handleTransferUpdateMessage(ctx context.Context, msg amqp091.Delivery) {
 		return
 	}
 
+	if transferRecord.Status == StatusCancelled {
+		log.Info().Msgf("Ignoring update from node %s for cancelled transfer %s", updateMsg.NodeId, updateMsg.TransferId)
+
+		return
+	}
+
 	if transferRecord.Status == StatusPending || transferRecord.Status == StatusInProgress {
 		handleTransferInProgress(ctx, updateMsg, transferRecord)
 	}
@@ -63,38 +79,80 @@ func handleTransferInProgress(ctx context.Context, updateMsg messaging.TransferU
 		return err
 	}
 
-	// Set the record to failed if it already isn't
-	if !transferRecord.DidFail() && !updateMsg.Success {
-		transferRecord.Status = StatusFailed
-		transferRecord.FailureReason = updateMsg.FailureReason
-	}
+	transferRecord, err = transferRepo.Update(ctx, transferRecord.Id, func(t *Transfer) error {
+		// the transfer may have been cancelled since the message was received
+		if t.Status != StatusPending && t.Status != StatusInProgress {
+			return errTransferStopped
+		}
+
+		// Set the record to failed if it already isn't
+		if !t.DidFail() && !updateMsg.Success {
+			t.SetFailed(updateMsg.FailureReason)
+		}
+
+		// set that the current node has been handled
+		t.Outputs[updateMsg.NodeId] = true
+
+		if !t.DidFail() {
+			t.Status = StatusInProgress
+		}
 
-	// set that the current node has been handled
-	transferRecord.Outputs[updateMsg.NodeId] = true
+		// if the transfer hasn't failed and we handled all nodes set it to processing complete
+		if !t.DidFail() && t.AllNodesHandled() {
+			t.Status = StatusProcessComplete
+		}
 
-	err = transferRepo.Save(ctx, transferRecord)
+		return nil
+	})
 	if err != nil {
+		if errors.Is(err, errTransferStopped) {
+			log.Info().Msgf("Ignoring update from node %s for transfer %s since it is no longer active", updateMsg.NodeId, updateMsg.TransferId)
+
+			return nil
+		}
+
 		log.Err(err).Msgf("failed to update transfer with id %s", updateMsg.TransferId)
 
 		return err
 	}
 
-	// if the transfer hasn't failed and we handled all nodes set it to processing complete
-	if !transferRecord.DidFail() && transferRecord.AllNodesHandled() {
-		transferRecord.Status = StatusProcessComplete
+	broadcastTransfer(transferRecord)
 
-		handleProcessedtransfer(ctx, transferRecord)
+	if transferRecord.Status == StatusProcessComplete {
 		// trigger process to download all media from the node
-		err = transferRepo.Save(ctx, transferRecord)
-		if err != nil {
-			log.Err(err).Msgf("failed to update transfer with id %s to processing complete", updateMsg.TransferId)
+		handleProcessedtransfer(ctx, transferRecord)
+	}
+
+	return nil
+}
+
+// fails the transfer unless it stopped being active, ie: it was cancelled while it was processed
+func failTransfer(ctx context.Context, transferId primitive.ObjectID, failureReason string) {
+	transferRepo, err := NewTransferRepository(ctx)
+	if err != nil {
+		log.Err(err).Msg("failed to instantiate transfer repository")
+
+		return
+	}
+
+	transfer, err := transferRepo.Update(ctx, transferId, func(t *Transfer) error {
+		if !t.IsActive() {
+			return errTransferStopped
+		}
 
-			return err
+		t.SetFailed(failureReason)
+
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, errTransferStopped) {
+			log.Err(err).Msg("failed to save transfer record")
 		}
 
+		return
 	}
 
-	return nil
+	broadcastTransfer(transfer)
 }
 
 func handleProcessedtransfer(ctx context.Context, transferRecord *Transfer) {
@@ -113,13 +171,7 @@ func handleProcessedtransfer(ctx context.Context, transferRecord *Transfer) {
 			errMsg := fmt.Errorf("cannot process transfer with id %q since the content on node %q is not ready", transferRecord.Id.Hex(), k)
 			log.Err(errMsg)
 
-			transferRecord.Status = StatusFailed
-			transferRecord.FailureReason = errMsg.Error()
-
-			err = transferRepo.Save(ctx, transferRecord)
-			if err != nil {
-				log.Err(err)
-			}
+			failTransfer(ctx, transferRecord.Id, errMsg.Error())
 
 			return
 		}
@@ -127,47 +179,92 @@ func handleProcessedtransfer(ctx context.Context, transferRecord *Transfer) {
 		nodeIds = append(nodeIds, k)
 	}
 
-	content, err := mediaDownloader{}.Download(ctx, transferRecord.Id, nodeIds)
+	archive, err := mediaDownloader{}.Download(ctx, transferRecord, nodeIds)
 	if err != nil {
 		log.Err(err).Msgf("cannot process transfer with id %q", transferRecord.Id.Hex())
 
-		transferRecord.Status = StatusFailed
-		transferRecord.FailureReason = err.Error()
+		failTransfer(ctx, transferRecord.Id, err.Error())
 
-		err = transferRepo.Save(ctx, transferRecord)
-		if err != nil {
-			log.Err(err).Msg("failed to save transfer record")
-		}
+		return
+	}
+
+	// the transfer may have been cancelled while the content was downloaded
+	current, err := transferRepo.GetById(ctx, transferRecord.Id)
+	if err != nil {
+		log.Err(err).Msgf("failed to find transfer with id %s", transferRecord.Id.Hex())
 
 		return
 	}
 
-	// we are the target, save the content to a file
+	if current.Status != StatusProcessComplete {
+		log.Info().Msgf("Discarding content of transfer %s since it is %s", transferRecord.Id.Hex(), current.Status)
+
+		newTransfersService().CleanupTransfer(ctx, transferRecord.Id.Hex())
+
+		return
+	}
+
+	// we are the target, the content is ready to be downloaded
 	if app.GetApp().NodeId == transferRecord.TargetId {
-		saveContent(ctx, transferRecord, content)
+		completeTransfer(ctx, transferRecord)
 
 		return
 	}
 
 	// another node is the target, send a message and let them handle the content
-	sendTransferReadyMessage(ctx, transferRecord, content)
+	sendTransferReadyMessage(ctx, transferRecord, archive)
 }
 
-func sendTransferReadyMessage(ctx context.Context, transfer *Transfer, content []byte) {
+func sendTransferReadyMessage(ctx context.Context, transfer *Transfer, archive string) {
 	log.Info().Msgf("Target for transfer %s is a media host, sending transfer ready message", transfer.Id.Hex())
+
+	// TODO: reference the archive instead of sending its content once messaging.TransferReadyMessage has a field for it
+	// and media hosts download the archive from it. Until then the archive is sent whole and has to fit in a message
+	info, err := os.Stat(archive)
+	if err != nil {
+		log.Err(err).Msgf("failed to read archive of transfer %s", transfer.Id.Hex())
+		failTransfer(ctx, transfer.Id, "failed to read the archive of the transfer")
+
+		return
+	}
+
+	maxMessageSize := int64(app.GetApp().Config.Transfers.MaxMessageSizeMB) << 20
+
+	// content is base64 encoded in the message
+	if info.Size()*4/3 > maxMessageSize {
+		failureReason := fmt.Sprintf(
+			"archive of %d MB is larger than the max message size of %d MB once encoded, it cannot be sent to node %s",
+			info.Size()>>20, maxMessageSize>>20, transfer.TargetId,
+		)
+
+		log.Error().Msgf("Failed to send transfer %s: %s", transfer.Id.Hex(), failureReason)
+		failTransfer(ctx, transfer.Id, failureReason)
+
+		return
+	}
+
+	content, err := os.ReadFile(archive)
+	if err != nil {
+		log.Err(err).Msgf("failed to read archive of transfer %s", transfer.Id.Hex())
+		failTransfer(ctx, transfer.Id, "failed to read the archive of the transfer")
+
+		return
+	}
+
 	msg := messaging.TransferReadyMessage{
 		TransferId: transfer.Id.Hex(),
 		// bytes of the zip file
 		Content:  content,
 		TargetId: transfer.TargetId,
 	}
-	err := rabbitmq.PublishMessage(ctx, messaging.TopicTransferReady, msg)
+	err = rabbitmq.PublishMessage(ctx, messaging.TopicTransferReady, msg)
 	if err != nil {
 		log.Err(err).Msg("failed to start async download")
 	}
 }
 
-func saveContent(ctx context.Context, transfer *Transfer, content []byte) {
+// completes a transfer targeting the manager once its archive is on disk
+func completeTransfer(ctx context.Context, transfer *Transfer) {
 	transferRepo, err := NewTransferRepository(ctx)
 	if err != nil {
 		log.Err(err).Msg("failed to instantiate transfer repository")
@@ -175,59 +272,29 @@ func saveContent(ctx context.Context, transfer *Transfer, content []byte) {
 		return
 	}
 
-	file, err := os.Create(path.Join(app.GetApp().Config.DownloadPath, transfer.Id.Hex()+".zip"))
-	if err != nil {
-		transfer.SetFailed(err.Error())
-
-		err := transferRepo.Save(ctx, transfer)
-		if err != nil {
-			log.Err(err).Msg("failed to save transfer record")
+	// all is good, set transfer record to complete
+	completed, err := transferRepo.Update(ctx, transfer.Id, func(t *Transfer) error {
+		if t.Status != StatusProcessComplete {
+			return errTransferStopped
 		}
-		return
-	}
 
-	defer file.Close()
+		t.Status = StatusComplete
 
-	_, err = file.Write(content)
+		return nil
+	})
 	if err != nil {
-		msg := "Failed to write content to file"
-		log.Err(err).Msg(msg)
-		transfer.SetFailed(msg)
-
-		err := transferRepo.Save(ctx, transfer)
-		if err != nil {
+		if errors.Is(err, errTransferStopped) {
+			log.Info().Msgf("Removing content of transfer %s since it is no longer active", transfer.Id.Hex())
+		} else {
 			log.Err(err).Msg("failed to save transfer record")
 		}
-		return
-	}
 
-	err = file.Sync()
-	if err != nil {
-		msg := "failed to commit file content to disk"
-		log.Err(err).Msg(msg)
-		transfer.SetFailed(msg)
+		newTransfersService().CleanupTransfer(ctx, transfer.Id.Hex())
 
-		err := transferRepo.Save(ctx, transfer)
-		if err != nil {
-			log.Err(err).Msg("failed to save transfer record")
-		}
 		return
 	}
 
-	// all is good, set transfer record to complete
-	transfer.Status = StatusComplete
-
-	err = transferRepo.Save(ctx, transfer)
-	if err != nil {
-		log.Err(err).Msg("failed to save transfer record")
-	}
-
-	// delete transfer archive after transfer expiry
-	time.AfterFunc(time.Until(transfer.Expiry), func() {
-		transferService := newTransfersService()
-
-		transferService.CleanupTransfer(ctx, transfer.Id.Hex())
-	})
+	broadcastTransfer(completed)
 }
 
 func handleTransferReadyUpdate(ctx context.Context, msg amqp091.Delivery) {
@@ -254,49 +321,48 @@ func handleTransferReadyUpdate(ctx context.Context, msg amqp091.Delivery) {
 		return
 	}
 
-	transferRecord, err := transferRepo.GetById(ctx, transferObjectId)
-	if err != nil {
-		log.Err(err).Msgf("failed to find transfer with id %s", updateMsg.TransferId)
+	// the transfer is completed before deleting the media so that a transfer cancelled in the meantime keeps its media
+	transferRecord, err := transferRepo.Update(ctx, transferObjectId, func(t *Transfer) error {
+		// should not be here if the status is not processing_complete
+		if t.Status != StatusProcessComplete {
+			return fmt.Errorf("%w, status is %s", errTransferStopped, t.Status)
+		}
 
-		return
-	}
+		t.Status = StatusComplete
 
-	// should not be here if the status is not processing_complete
-	if transferRecord.Status != StatusProcessComplete {
-		log.
-			Err(err).
-			Msgf(
-				"invalid ready update message for transfer with id %s since transfer status is %s",
-				updateMsg.TransferId,
-				transferRecord.Status,
-			)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, errTransferStopped) {
+			log.Info().Msgf("Ignoring ready update message for transfer with id %s: %s", updateMsg.TransferId, err)
+		} else {
+			log.
+				Err(err).
+				Msgf(
+					"failed to update transfer status to complete for transfer with id %s ",
+					updateMsg.TransferId,
+				)
+		}
 
 		return
 	}
 
-	// since the content was transfered from a node to another, send delete messages to the input nodes
-	deleteMsg := messaging.DeleteMediaMessage{
-		MediaToDelete: transferRecord.Inputs,
-	}
-
-	err = rabbitmq.PublishMessage(ctx, messaging.TopicDeleteMedia, deleteMsg)
-	if err != nil {
-		log.Err(err).Msgf("Failed to publish message to delete media")
-	}
+	broadcastTransfer(transferRecord)
 
-	transferRecord.Status = StatusComplete
-
-	err = transferRepo.Save(ctx, transferRecord)
-	if err != nil {
-		log.
-			Err(err).
-			Msgf(
-				"failed to update transfer status to complete for transfer with id %s ",
-				updateMsg.TransferId,
-			)
+	// since the content was moved from a node to another, send delete messages to the input nodes
+	if transferRecord.IsMove() {
+		deleteMsg := messaging.DeleteMediaMessage{
+			MediaToDelete: transferRecord.Inputs,
+		}
 
+		err = rabbitmq.PublishMessage(ctx, messaging.TopicDeleteMedia, deleteMsg)
+		if err != nil {
+			log.Err(err).Msgf("Failed to publish message to delete media")
+		}
 	}
 
+	// the target downloaded the archive, it is no longer needed
+	newTransfersService().CleanupTransfer(ctx, updateMsg.TransferId)
 }
 
 func init() {
@@ -2,34 +2,80 @@ package transfer
 
 import (
 	"archive/zip"
-	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"os"
+	"path"
 	"sync"
 
+	"github.com/egfanboy/mediapire-manager/internal/app"
 	"github.com/egfanboy/mediapire-manager/internal/node"
-	mhApi "github.com/egfanboy/mediapire-media-host/pkg/api"
+	"github.com/egfanboy/mediapire-manager/pkg/types"
 	"github.com/rs/zerolog/log"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// returns the path of the archive holding the content of the transfer
+func archivePath(transferId string) string {
+	return path.Join(app.GetApp().Config.DownloadPath, transferId+".zip")
+}
+
+// the generated media host client reads the whole body in memory, archives are streamed to disk instead
+var downloadClient = &http.Client{}
+
 type downloaderQueue struct {
 	queue      []node.NodeConfig
 	transferId string
-	content    map[node.NodeConfig][]byte
+	// directory holding the archive downloaded from each node
+	workDir  string
+	mu       sync.Mutex
+	archives map[node.NodeConfig]string
+	progress *progressTracker
 }
 
 func (q *downloaderQueue) processNode(ctx context.Context, n node.NodeConfig) error {
-	client := mhApi.NewClient(n)
+	url := fmt.Sprintf("%s://%s:%v/api/v1/transfers/%s/download", n.Scheme(), n.Host(), n.Port(), q.transferId)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := downloadClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("node %s responded with status %d when downloading transfer %s", n.Id, response.StatusCode, q.transferId)
+	}
+
+	archive := path.Join(q.workDir, n.Id+".zip")
 
-	content, _, err := client.DownloadTransfer(ctx, q.transferId)
+	file, err := os.Create(archive)
 	if err != nil {
 		return err
 	}
 
-	q.content[n] = content
+	defer file.Close()
+
+	if response.ContentLength > 0 {
+		q.progress.setBytesTotal(n.Id, response.ContentLength)
+	}
+
+	_, err = io.Copy(io.MultiWriter(file, progressWriter{ctx: ctx, nodeId: n.Id, tracker: q.progress}), response.Body)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	q.archives[n] = archive
+	q.mu.Unlock()
 
 	return nil
 }
@@ -72,60 +118,148 @@ func (q *downloaderQueue) ProcessQueue(ctx context.Context) error {
 	return nil
 }
 
-func (q *downloaderQueue) GetContent(ctx context.Context) ([]byte, error) {
-	numberOfNodes := len(q.queue)
-	result := new(bytes.Buffer)
-	zipWriter := zip.NewWriter(result)
+// merges the archives of every node into the archive at dest along with a manifest and a playlist of its files.
+// entries are copied without being decompressed
+func (q *downloaderQueue) WriteArchive(ctx context.Context, dest string, layout *archiveLayout) error {
+	file, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
 
-	for _, nodeContent := range q.content {
-		// this is the only node, just return the content
-		if numberOfNodes == 1 {
-			return nodeContent, nil
+	zipWriter := zip.NewWriter(file)
+
+	// nodes are merged in the order of the queue so that files with the same name are numbered the same way every time
+	for _, n := range q.queue {
+		archive, ok := q.archives[n]
+		if !ok {
+			continue
 		}
 
-		br := bytes.NewReader(nodeContent)
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
 
-		zipReader, err := zip.NewReader(br, br.Size())
+		err = q.copyArchive(ctx, zipWriter, archive, n.Id, layout)
 		if err != nil {
-			return nil, err
+			return err
 		}
+	}
 
-		for _, file := range zipReader.File {
-			zipItem, err := file.Open()
-			if err != nil {
-				return nil, err
-			}
+	manifest, err := json.MarshalIndent(layout.manifest(q.transferId), "", "  ")
+	if err != nil {
+		return err
+	}
 
-			w, err := zipWriter.Create(file.Name)
-			if err != nil {
-				return nil, err
-			}
+	err = writeArchiveFile(zipWriter, manifestFileName, manifest)
+	if err != nil {
+		return err
+	}
 
-			_, err = io.Copy(w, zipItem)
-			if err != nil {
-				return nil, err
-			}
+	err = writeArchiveFile(zipWriter, playlistFileName, []byte(layout.playlist()))
+	if err != nil {
+		return err
+	}
+
+	err = zipWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+func (q *downloaderQueue) copyArchive(ctx context.Context, zipWriter *zip.Writer, archive string, nodeId string, layout *archiveLayout) error {
+	zipReader, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+
+	defer zipReader.Close()
+
+	for _, file := range zipReader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		header := file.FileHeader
+		header.Name = layout.add(nodeId, file.Name)
+
+		w, err := zipWriter.CreateRaw(&header)
+		if err != nil {
+			return err
+		}
+
+		r, err := file.OpenRaw()
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(w, r)
+		if err != nil {
+			return err
 		}
+
+		q.progress.addMergedFile(ctx)
 	}
 
-	err := zipWriter.Close()
-	return result.Bytes(), err
+	return nil
+}
+
+func writeArchiveFile(zipWriter *zip.Writer, name string, content []byte) error {
+	w, err := zipWriter.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(content)
+
+	return err
+}
+
+// returns the media items of the transfer, the archive is still laid out without their tags if they cannot be found
+func getTransferMedia(ctx context.Context, transfer *Transfer) []types.MediaItem {
+	if lookupMedia == nil {
+		return []types.MediaItem{}
+	}
+
+	mediaIds := make([]string, 0)
+	for _, ids := range transfer.Inputs {
+		mediaIds = append(mediaIds, ids...)
+	}
+
+	items, err := lookupMedia(ctx, mediaIds)
+	if err != nil {
+		log.Err(err).Msgf("failed to get media of transfer %s, files are laid out without their tags", transfer.Id.Hex())
+		return []types.MediaItem{}
+	}
+
+	return items
 }
 
 type mediaDownloader struct{}
 
-func (mediaDownloader) Download(ctx context.Context, transferId primitive.ObjectID, nodeIds []string) ([]byte, error) {
+// Download gathers the content of the transfer from every node into a single archive on disk and returns its path
+func (mediaDownloader) Download(ctx context.Context, transfer *Transfer, nodeIds []string) (string, error) {
+	transferId := transfer.Id
 	log.Info().Msgf("Starting download of content for transfer %s", transferId.Hex())
 	nodes := make([]node.NodeConfig, 0)
 
+	transferRepo, err := NewTransferRepository(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	nodeService, err := node.NewNodeService()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	allNodes, err := nodeService.GetAllNodes(ctx)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	for _, nodeId := range nodeIds {
@@ -141,25 +275,61 @@ func (mediaDownloader) Download(ctx context.Context, transferId primitive.Object
 		if node != nil {
 			nodes = append(nodes, *node)
 		} else {
-			return nil, fmt.Errorf("no node found with id %q", nodeId)
+			return "", fmt.Errorf("no node found with id %q", nodeId)
 		}
 	}
 
-	queue := &downloaderQueue{queue: nodes, content: map[node.NodeConfig][]byte{}, transferId: transferId.Hex()}
+	// kept next to the final archive so that it can be moved rather than copied
+	workDir, err := os.MkdirTemp(app.GetApp().Config.DownloadPath, transferId.Hex()+"-*")
+	if err != nil {
+		return "", err
+	}
+
+	defer os.RemoveAll(workDir)
+
+	queue := &downloaderQueue{
+		queue:      nodes,
+		transferId: transferId.Hex(),
+		workDir:    workDir,
+		archives:   make(map[node.NodeConfig]string),
+		progress:   newProgressTracker(transferId, transferRepo),
+	}
+
+	queue.progress.setPhase(ctx, types.TransferPhaseDownloading)
 
 	err = queue.ProcessQueue(ctx)
 	if err != nil {
 		log.Err(err).Msg("Failed to process download queue")
 
-		return nil, err
+		return "", err
+	}
+
+	// the archive is only visible once it is complete
+	partial := path.Join(workDir, "merged.zip")
+
+	queue.progress.setPhase(ctx, types.TransferPhaseMerging)
+
+	layout := transfer.Layout
+	// transfers created before layouts were configurable
+	if layout == "" {
+		layout = types.TransferLayoutFlat
 	}
 
-	content, err := queue.GetContent(ctx)
+	err = queue.WriteArchive(ctx, partial, newArchiveLayout(layout, getTransferMedia(ctx, transfer)))
 	if err != nil {
 		log.Err(err).Msg("Failed to merge downloaded content")
 
-		return nil, err
+		return "", err
+	}
+
+	dest := archivePath(transferId.Hex())
+
+	err = os.Rename(partial, dest)
+	if err != nil {
+		return "", err
 	}
 
-	return content, nil
+	queue.progress.setPhase(ctx, types.TransferPhaseDone)
+
+	return dest, nil
 }
@@ -0,0 +1,196 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/egfanboy/mediapire-manager/internal/utils"
+	"github.com/egfanboy/mediapire-manager/pkg/types"
+)
+
+const (
+	manifestFileName = "manifest.json"
+	playlistFileName = "playlist.m3u8"
+
+	unknownArtist = "Unknown Artist"
+	unknownAlbum  = "Unknown Album"
+)
+
+var validLayouts = map[string]bool{
+	types.TransferLayoutFlat: true,
+	types.TransferLayoutNode: true,
+	types.TransferLayoutTags: true,
+}
+
+// looks up media items by id. The media package depends on this package so it registers the lookup instead
+type mediaLookup func(ctx context.Context, mediaIds []string) ([]types.MediaItem, error)
+
+var lookupMedia mediaLookup
+
+// RegisterMediaLookup sets how the media of a transfer is looked up to lay out its archive
+func RegisterMediaLookup(lookup mediaLookup) {
+	lookupMedia = lookup
+}
+
+// a file of the archive along with the media item it was matched to
+type archiveFile struct {
+	types.MediaItemMapping
+	Path string
+	// nil when the file could not be matched to a media item of the transfer
+	item *types.MediaItem
+}
+
+// decides the path of every file in the archive so that no two files share a path
+type archiveLayout struct {
+	layout string
+	used   map[string]bool
+	// media items of the transfer not matched to a file yet, keyed by node id then by file name.
+	// A node can have several items with the same name, each file is matched to a different one
+	items map[string]map[string][]types.MediaItem
+	files []archiveFile
+}
+
+func newArchiveLayout(layout string, items []types.MediaItem) *archiveLayout {
+	l := &archiveLayout{
+		layout: layout,
+		used:   map[string]bool{manifestFileName: true, playlistFileName: true},
+		items:  make(map[string]map[string][]types.MediaItem),
+	}
+
+	for _, item := range items {
+		if _, ok := l.items[item.NodeId]; !ok {
+			l.items[item.NodeId] = make(map[string][]types.MediaItem)
+		}
+
+		l.items[item.NodeId][item.Name] = append(l.items[item.NodeId][item.Name], item)
+	}
+
+	return l
+}
+
+// returns the path of the entry of the node's archive in the merged archive
+func (l *archiveLayout) add(nodeId string, entryName string) string {
+	name := path.Base(entryName)
+	file := archiveFile{MediaItemMapping: types.MediaItemMapping{NodeId: nodeId}}
+
+	if candidates := l.items[nodeId][name]; len(candidates) > 0 {
+		item := candidates[0]
+		l.items[nodeId][name] = candidates[1:]
+
+		file.MediaId = item.Id
+		file.item = &item
+	}
+
+	var dir string
+	switch l.layout {
+	case types.TransferLayoutNode:
+		dir = sanitizePathSegment(nodeId)
+	case types.TransferLayoutTags:
+		artist, album := unknownArtist, unknownAlbum
+		if file.item != nil {
+			metadata := getMetadata(*file.item)
+
+			if value, _ := metadata["artist"].(string); strings.TrimSpace(value) != "" {
+				artist = value
+			}
+
+			if value, _ := metadata["album"].(string); strings.TrimSpace(value) != "" {
+				album = value
+			}
+		}
+
+		dir = path.Join(sanitizePathSegment(artist), sanitizePathSegment(album))
+	}
+
+	file.Path = l.reserve(path.Join(dir, sanitizePathSegment(name)))
+	l.files = append(l.files, file)
+
+	return file.Path
+}
+
+// numbers the path until it is not used by another file, ie: song.mp3, song (2).mp3
+func (l *archiveLayout) reserve(p string) string {
+	extension := path.Ext(p)
+	base := strings.TrimSuffix(p, extension)
+
+	result := p
+	for i := 2; l.used[result]; i++ {
+		result = fmt.Sprintf("%s (%d)%s", base, i, extension)
+	}
+
+	l.used[result] = true
+
+	return result
+}
+
+func (l *archiveLayout) manifest(transferId string) types.TransferManifest {
+	result := types.TransferManifest{TransferId: transferId, Layout: l.layout, Files: make([]types.TransferManifestFile, len(l.files))}
+
+	for i, file := range l.files {
+		result.Files[i] = types.TransferManifestFile{MediaItemMapping: file.MediaItemMapping, Path: file.Path}
+	}
+
+	return result
+}
+
+// returns an extended m3u playlist of every file, paths are relative to the root of the archive
+func (l *archiveLayout) playlist() string {
+	var sb strings.Builder
+
+	sb.WriteString("#EXTM3U\n")
+
+	for _, file := range l.files {
+		duration := -1
+		title := strings.TrimSuffix(path.Base(file.Path), path.Ext(file.Path))
+
+		if file.item != nil {
+			metadata := getMetadata(*file.item)
+
+			if value, ok := metadata["duration"].(float64); ok {
+				duration = int(math.Round(value))
+			}
+
+			if value, _ := metadata["title"].(string); value != "" {
+				title = value
+			}
+
+			if value, _ := metadata["artist"].(string); value != "" {
+				title = fmt.Sprintf("%s - %s", value, title)
+			}
+		}
+
+		sb.WriteString(fmt.Sprintf("#EXTINF:%d,%s\n%s\n", duration, title, file.Path))
+	}
+
+	return sb.String()
+}
+
+func getMetadata(item types.MediaItem) map[string]interface{} {
+	result, err := utils.ConvertStruct[interface{}, map[string]interface{}](item.Metadata)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+
+	return result
+}
+
+// tags can contain characters that would create folders or escape the archive
+func sanitizePathSegment(value string) string {
+	result := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':':
+			return '_'
+		}
+
+		return r
+	}, strings.TrimSpace(value))
+
+	if result == "" || result == "." || result == ".." {
+		return "_"
+	}
+
+	return result
+}
@@ -0,0 +1,123 @@
+package transfer
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// progress is saved and broadcast at most this often while the content of a transfer is downloaded and merged
+const progressInterval = time.Second
+
+// records the progress of gathering the content of a transfer from its nodes
+type progressTracker struct {
+	transferId primitive.ObjectID
+	repo       TransferRepository
+
+	mu          sync.Mutex
+	phase       string
+	nodes       map[string]nodeProgress
+	filesMerged int
+	lastFlush   time.Time
+
+	// keeps flushes in order so that older progress does not overwrite newer progress
+	flushMu sync.Mutex
+}
+
+func newProgressTracker(transferId primitive.ObjectID, repo TransferRepository) *progressTracker {
+	return &progressTracker{transferId: transferId, repo: repo, nodes: make(map[string]nodeProgress)}
+}
+
+// starts a phase, it is saved right away
+func (p *progressTracker) setPhase(ctx context.Context, phase string) {
+	p.mu.Lock()
+	p.phase = phase
+	p.mu.Unlock()
+
+	p.flush(ctx, true)
+}
+
+func (p *progressTracker) setBytesTotal(nodeId string, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	progress := p.nodes[nodeId]
+	progress.BytesTotal = total
+	p.nodes[nodeId] = progress
+}
+
+func (p *progressTracker) addBytes(ctx context.Context, nodeId string, n int64) {
+	p.mu.Lock()
+	progress := p.nodes[nodeId]
+	progress.BytesDownloaded += n
+	p.nodes[nodeId] = progress
+	p.mu.Unlock()
+
+	p.flush(ctx, false)
+}
+
+func (p *progressTracker) addMergedFile(ctx context.Context) {
+	p.mu.Lock()
+	p.filesMerged++
+	p.mu.Unlock()
+
+	p.flush(ctx, false)
+}
+
+// saves the progress on the transfer, unless forced it is skipped when the last save is too recent
+func (p *progressTracker) flush(ctx context.Context, force bool) {
+	p.flushMu.Lock()
+	defer p.flushMu.Unlock()
+
+	p.mu.Lock()
+	if !force && time.Since(p.lastFlush) < progressInterval {
+		p.mu.Unlock()
+		return
+	}
+
+	p.lastFlush = time.Now()
+
+	phase, filesMerged := p.phase, p.filesMerged
+	nodes := make(map[string]nodeProgress, len(p.nodes))
+	for nodeId, progress := range p.nodes {
+		nodes[nodeId] = progress
+	}
+	p.mu.Unlock()
+
+	transfer, err := p.repo.Update(ctx, p.transferId, func(t *Transfer) error {
+		if t.Progress.Nodes == nil {
+			t.Progress.Nodes = make(map[string]nodeProgress)
+		}
+
+		t.Progress.Phase = phase
+		t.Progress.FilesMerged = filesMerged
+
+		for nodeId, progress := range nodes {
+			t.Progress.Nodes[nodeId] = progress
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msgf("failed to save progress of transfer %s", p.transferId.Hex())
+		return
+	}
+
+	broadcastTransfer(transfer)
+}
+
+// counts the bytes downloaded from a node
+type progressWriter struct {
+	ctx     context.Context
+	nodeId  string
+	tracker *progressTracker
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	w.tracker.addBytes(w.ctx, w.nodeId, int64(len(b)))
+
+	return len(b), nil
+}
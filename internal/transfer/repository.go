@@ -3,16 +3,62 @@ package transfer
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	mediapireMongo "github.com/egfanboy/mediapire-manager/internal/mongo"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+type transferFilter struct {
+	Statuses      []TransferStatus
+	TargetId      *string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	ExpiresBefore *time.Time
+}
+
+func (f transferFilter) toQuery() bson.M {
+	query := bson.M{}
+
+	if len(f.Statuses) > 0 {
+		query["status"] = bson.M{"$in": f.Statuses}
+	}
+
+	if f.TargetId != nil {
+		query["target_id"] = *f.TargetId
+	}
+
+	createdAt := bson.M{}
+	if f.CreatedAfter != nil {
+		createdAt["$gte"] = *f.CreatedAfter
+	}
+
+	if f.CreatedBefore != nil {
+		createdAt["$lte"] = *f.CreatedBefore
+	}
+
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	if f.ExpiresBefore != nil {
+		query["expiry"] = bson.M{"$lte": *f.ExpiresBefore}
+	}
+
+	return query
+}
+
 type TransferRepository interface {
 	Save(ctx context.Context, d *Transfer) error
 	GetById(ctx context.Context, objectId primitive.ObjectID) (*Transfer, error)
+	// GetAll returns the transfers matching the filter, newest first
+	GetAll(ctx context.Context, filter transferFilter) ([]*Transfer, error)
+	// Update reads the transfer, applies fn and saves it. fn is applied again to the latest transfer when it was modified concurrently
+	Update(ctx context.Context, objectId primitive.ObjectID, fn func(t *Transfer) error) (*Transfer, error)
 }
 
 type repo struct {
@@ -26,6 +72,9 @@ func (r *repo) getCollection() *mongo.Collection {
 }
 
 func (r *repo) Save(ctx context.Context, d *Transfer) error {
+	d.UpdatedAt = time.Now()
+	d.Version++
+
 	_, err := r.GetById(ctx, d.Id)
 
 	// Already exists, update it
@@ -56,6 +105,65 @@ func (r *repo) GetById(ctx context.Context, objectId primitive.ObjectID) (*Trans
 	return dl, err
 }
 
+func (r *repo) GetAll(ctx context.Context, filter transferFilter) ([]*Transfer, error) {
+	// object ids are time based, use them to order transfers created before timestamps were tracked
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+
+	cur, err := r.getCollection().Find(ctx, filter.toQuery(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Transfer, 0)
+
+	err = cur.All(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *repo) Update(ctx context.Context, objectId primitive.ObjectID, fn func(t *Transfer) error) (*Transfer, error) {
+	for attempt := 0; attempt < mediapireMongo.MaxUpdateAttempts; attempt++ {
+		t, err := r.GetById(ctx, objectId)
+		if err != nil {
+			return nil, err
+		}
+
+		err = fn(t)
+		if err != nil {
+			return nil, err
+		}
+
+		saved, err := r.replaceIfUnchanged(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+
+		if saved {
+			return t, nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to update transfer %s since it kept being modified concurrently", objectId.Hex())
+}
+
+// replaces the transfer unless it was saved since it was read, returns whether it was replaced
+func (r *repo) replaceIfUnchanged(ctx context.Context, t *Transfer) (bool, error) {
+	version := t.Version
+
+	t.UpdatedAt = time.Now()
+	t.Version++
+
+	result, err := r.getCollection().ReplaceOne(ctx, mediapireMongo.VersionFilter(t.Id, version), t)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
 func NewTransferRepository(ctx context.Context) (TransferRepository, error) {
 	r := &repo{}
 
@@ -0,0 +1,142 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/egfanboy/mediapire-manager/internal/app"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// archives are named after their transfer and merged in a work directory named after it, ie: <id>.zip and <id>-123456
+var downloadFileRegEx = regexp.MustCompile(`^([a-f0-9]{24})(\.zip|-\d+)$`)
+
+type sweeper struct {
+	repo TransferRepository
+}
+
+// expires the transfers whose expiry passed and deletes their archives
+func (s *sweeper) expire(ctx context.Context) {
+	now := time.Now()
+
+	expired, err := s.repo.GetAll(ctx, transferFilter{
+		Statuses:      []TransferStatus{StatusPending, StatusInProgress, StatusProcessComplete, StatusComplete},
+		ExpiresBefore: &now,
+	})
+	if err != nil {
+		log.Err(err).Msg("failed to get expired transfers")
+		return
+	}
+
+	for _, t := range expired {
+		expiredTransfer, err := s.repo.Update(ctx, t.Id, func(t *Transfer) error {
+			// the transfer may have failed or been cancelled since it was fetched
+			if !t.IsActive() && t.Status != StatusComplete {
+				return errTransferStopped
+			}
+
+			t.Status = StatusExpired
+
+			return nil
+		})
+		if err != nil {
+			if !errors.Is(err, errTransferStopped) {
+				log.Err(err).Msgf("failed to expire transfer %s", t.Id.Hex())
+			}
+
+			continue
+		}
+
+		log.Info().Msgf("Transfer %s expired", t.Id.Hex())
+		broadcastTransfer(expiredTransfer)
+
+		newTransfersService().CleanupTransfer(ctx, t.Id.Hex())
+	}
+}
+
+// deletes the archives and work directories in the download directory whose transfer no longer needs them
+func (s *sweeper) removeOrphans(ctx context.Context) {
+	downloadPath := app.GetApp().Config.DownloadPath
+
+	entries, err := os.ReadDir(downloadPath)
+	if err != nil {
+		log.Err(err).Msg("failed to list the download directory")
+		return
+	}
+
+	for _, entry := range entries {
+		// only files created for transfers are removed
+		match := downloadFileRegEx.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+
+		transferId, err := primitive.ObjectIDFromHex(match[1])
+		if err != nil {
+			continue
+		}
+
+		t, err := s.repo.GetById(ctx, transferId)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Err(err).Msgf("failed to get transfer %s", transferId.Hex())
+			continue
+		}
+
+		if err == nil {
+			isArchive := match[2] == ".zip"
+
+			// archives are kept while they can be downloaded and work directories while the content is gathered
+			if (isArchive && t.HasArchive()) || (!isArchive && t.IsActive()) {
+				continue
+			}
+		}
+
+		log.Info().Msgf("Removing %s from the download directory since its transfer no longer needs it", entry.Name())
+
+		err = os.RemoveAll(path.Join(downloadPath, entry.Name()))
+		if err != nil {
+			log.Err(err).Msgf("failed to remove %s from the download directory", entry.Name())
+		}
+	}
+}
+
+func (s *sweeper) sweep(ctx context.Context) {
+	s.expire(ctx)
+	s.removeOrphans(ctx)
+}
+
+// StartSweeper expires transfers and deletes the archives that are no longer needed, right away to clean up what was
+// left behind before a restart and then periodically until the context is done
+func StartSweeper(ctx context.Context) error {
+	repo, err := NewTransferRepository(ctx)
+	if err != nil {
+		return err
+	}
+
+	s := &sweeper{repo: repo}
+	interval := time.Duration(app.GetApp().Config.Transfers.SweeperIntervalSeconds) * time.Second
+
+	go func() {
+		s.sweep(ctx)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.sweep(ctx)
+			}
+		}
+	}()
+
+	return nil
+}
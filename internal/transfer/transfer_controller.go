@@ -1,15 +1,31 @@
 package transfer
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/egfanboy/mediapire-common/exceptions"
 	"github.com/egfanboy/mediapire-common/router"
 	"github.com/egfanboy/mediapire-manager/internal/app"
 	"github.com/egfanboy/mediapire-manager/pkg/types"
+	"github.com/egfanboy/mediapire-manager/pkg/types/pagination"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
 )
 
-const basePath = "/transfers"
+const (
+	basePath = "/transfers"
+
+	queryParamStatus        = "status"
+	queryParamTargetId      = "targetId"
+	queryParamCreatedAfter  = "createdAfter"
+	queryParamCreatedBefore = "createdBefore"
+)
 
 type transfersController struct {
 	builders []func() router.RouteBuilder
@@ -25,22 +41,142 @@ func (c transfersController) GetApis() (routes []router.RouteBuilder) {
 	return
 }
 
-func (c transfersController) DownloadTransfer() router.RouteBuilder {
+func (c transfersController) GetTransfers() router.RouteBuilder {
 	return router.NewV1RouteBuilder().
 		SetMethod(http.MethodOptions, http.MethodGet).
-		SetPath(basePath + "/{transferId}/download").
-		SetDataType(router.DataTypeFile).
+		SetPath(basePath).
 		SetReturnCode(http.StatusOK).
+		AddQueryParam(router.QueryParam{Name: queryParamStatus, Required: false}).
+		AddQueryParam(router.QueryParam{Name: queryParamTargetId, Required: false}).
+		AddQueryParam(router.QueryParam{Name: queryParamCreatedAfter, Required: false}).
+		AddQueryParam(router.QueryParam{Name: queryParamCreatedBefore, Required: false}).
+		AddQueryParam(pagination.PageQueryParam).
+		AddQueryParam(pagination.LimitQueryParam).
 		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
-			transferId, ok := p.Params["transferId"]
-			if !ok {
-				return nil, errors.New("transferId not found in API path")
+			filter, err := newTransferFilter(p)
+			if err != nil {
+				return nil, err
 			}
 
-			return c.service.Download(request.Context(), transferId)
+			var paginationParams *pagination.ApiPaginationParams
+			if _, ok := p.Params[pagination.PageQueryParam.Name]; ok {
+				pagination, err := pagination.NewApiPaginationParams(p)
+				if err != nil {
+					return nil, err
+				}
+
+				paginationParams = &pagination
+			}
+
+			return c.service.GetTransfers(request.Context(), filter, paginationParams)
 		})
 }
 
+func newTransferFilter(p router.RouteParams) (filter transferFilter, err error) {
+	if statusQuery, ok := p.Params[queryParamStatus]; ok {
+		for _, status := range strings.Split(statusQuery, ",") {
+			filter.Statuses = append(filter.Statuses, TransferStatus(status))
+		}
+	}
+
+	if targetId, ok := p.Params[queryParamTargetId]; ok {
+		filter.TargetId = &targetId
+	}
+
+	filter.CreatedAfter, err = pagination.ParseTimeParam(p, queryParamCreatedAfter)
+	if err != nil {
+		return
+	}
+
+	filter.CreatedBefore, err = pagination.ParseTimeParam(p, queryParamCreatedBefore)
+
+	return
+}
+
+// serves the archive of the transfer from disk. It is not built by the router since it returns the whole content in memory
+// and cannot resume an interrupted download with a Range request
+func (c transfersController) DownloadTransfer(w http.ResponseWriter, request *http.Request) {
+	transferId := mux.Vars(request)["transferId"]
+
+	file, err := c.service.OpenArchive(request.Context(), transferId)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// archives take longer than the write timeout of the server to download
+	err = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		log.Err(err).Msgf("failed to remove write deadline for download of transfer %s", transferId)
+	}
+
+	// an archive never changes once written, its size and modification time identify it for If-Range
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%x-%x"`, transferId, info.Size(), info.ModTime().UnixNano()))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": transferId + ".zip"}))
+
+	// handles Range, If-Range and Content-Length
+	http.ServeContent(w, request, transferId+".zip", info.ModTime(), file)
+}
+
+// body of an error of the download endpoint
+type errorResponse struct {
+	Message    string `json:"message"`
+	StatusCode int    `json:"statusCode"`
+}
+
+// writes the error as JSON so that clients read it like the errors of the other endpoints
+func writeError(w http.ResponseWriter, err error) {
+	statusCode := http.StatusInternalServerError
+
+	var apiErr *exceptions.ApiException
+	if errors.As(err, &apiErr) {
+		statusCode = apiErr.StatusCode
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	encodeErr := json.NewEncoder(w).Encode(errorResponse{Message: err.Error(), StatusCode: statusCode})
+	if encodeErr != nil {
+		log.Err(encodeErr).Msg("failed to write error response")
+	}
+}
+
+// RegisterDownloadHandler serves the archives of transfers on the router
+func RegisterDownloadHandler(r *mux.Router) {
+	c := transfersController{service: newTransfersService()}
+
+	r.HandleFunc("/api/v1"+basePath+"/{transferId}/download", withCors(c.DownloadTransfer)).
+		Methods(http.MethodOptions, http.MethodGet, http.MethodHead)
+}
+
+// applies the same CORS handling as the routes built by the router and answers preflight requests.
+// Clients resuming a download send Range and If-Range and need to read the headers describing the partial content
+func withCors(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, request *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join([]string{http.MethodOptions, http.MethodGet, http.MethodHead}, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", "Range, If-Range")
+		w.Header().Set("Access-Control-Expose-Headers", "Accept-Ranges, Content-Range, Content-Length, Content-Disposition, ETag")
+
+		if request.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		handler(w, request)
+	}
+}
+
 func (c transfersController) GetTransferById() router.RouteBuilder {
 	return router.NewV1RouteBuilder().
 		SetMethod(http.MethodOptions, http.MethodGet).
@@ -56,6 +192,21 @@ func (c transfersController) GetTransferById() router.RouteBuilder {
 		})
 }
 
+func (c transfersController) CancelTransfer() router.RouteBuilder {
+	return router.NewV1RouteBuilder().
+		SetMethod(http.MethodOptions, http.MethodPost).
+		SetPath(basePath + "/{transferId}/cancel").
+		SetReturnCode(http.StatusOK).
+		SetHandler(func(request *http.Request, p router.RouteParams) (interface{}, error) {
+			transferId, ok := p.Params["transferId"]
+			if !ok {
+				return nil, errors.New("transferId not found in API path")
+			}
+
+			return c.service.CancelTransfer(request.Context(), transferId)
+		})
+}
+
 func (c transfersController) CreateTransfer() router.RouteBuilder {
 	return router.NewV1RouteBuilder().
 		SetMethod(http.MethodOptions, http.MethodPost).
@@ -77,9 +228,10 @@ func initController() transfersController {
 
 	c.builders = append(
 		c.builders,
-		c.DownloadTransfer,
+		c.GetTransfers,
 		c.GetTransferById,
 		c.CreateTransfer,
+		c.CancelTransfer,
 	)
 
 	return c
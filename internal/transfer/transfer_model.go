@@ -1,9 +1,12 @@
 package transfer
 
 import (
+	"errors"
 	"time"
 
 	"github.com/egfanboy/mediapire-common/types"
+	"github.com/egfanboy/mediapire-manager/internal/app"
+	managerTypes "github.com/egfanboy/mediapire-manager/pkg/types"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -16,8 +19,26 @@ const (
 	StatusComplete        TransferStatus = "complete"
 	StatusFailed          TransferStatus = "failed"
 	StatusExpired         TransferStatus = "expired"
+	StatusCancelled       TransferStatus = "cancelled"
 )
 
+var (
+	errTransferCancelled = errors.New("transfer was cancelled")
+	// returned when the transfer stops being active while it is processed
+	errTransferStopped = errors.New("transfer is no longer active")
+)
+
+type nodeProgress struct {
+	BytesDownloaded int64 `bson:"bytes_downloaded"`
+	BytesTotal      int64 `bson:"bytes_total"`
+}
+
+type transferProgress struct {
+	Phase       string                  `bson:"phase"`
+	Nodes       map[string]nodeProgress `bson:"nodes"`
+	FilesMerged int                     `bson:"files_merged"`
+}
+
 type Transfer struct {
 	Id       primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
 	TargetId string              `json:"targetId" bson:"target_id"`
@@ -27,6 +48,15 @@ type Transfer struct {
 	Status        TransferStatus  `json:"status" bson:"status"`
 	FailureReason string          `json:"failureReason" bson:"failure_reason"`
 	Expiry        time.Time       `json:"expiry" bson:"expiry"`
+	// layout of the files in the archive of the transfer
+	Layout string `json:"layout" bson:"layout"`
+	// copy or move, whether the media is deleted from the input nodes once it reached the target node
+	Mode      string           `json:"mode" bson:"mode"`
+	Progress  transferProgress `json:"progress" bson:"progress"`
+	CreatedAt time.Time        `json:"createdAt" bson:"created_at"`
+	UpdatedAt time.Time        `json:"updatedAt" bson:"updated_at"`
+	// incremented on every save so that concurrent updates do not overwrite each other
+	Version int `json:"-" bson:"version"`
 }
 
 func (t *Transfer) ToApiResponse() types.Transfer {
@@ -38,6 +68,102 @@ func (t *Transfer) ToApiResponse() types.Transfer {
 	}
 }
 
+// ToApiDetails returns the transfer along with its inputs and progress, used by the transfer endpoints and websocket updates
+func (t *Transfer) ToApiDetails() managerTypes.TransferItem {
+	result := managerTypes.TransferItem{
+		Id:            t.Id.Hex(),
+		TargetId:      t.TargetId,
+		Status:        string(t.Status),
+		FailureReason: t.FailureReason,
+		Expiry:        t.Expiry,
+		Inputs:        t.Inputs,
+		ItemCounts:    t.GetItemCounts(),
+		Layout:        t.Layout,
+		Mode:          t.getMode(),
+		Progress:      t.getApiProgress(),
+		CreatedAt:     t.CreatedAt,
+		UpdatedAt:     t.UpdatedAt,
+	}
+
+	// transfers created before timestamps were tracked
+	if t.CreatedAt.IsZero() {
+		result.CreatedAt = t.Id.Timestamp()
+	}
+
+	return result
+}
+
+func (t *Transfer) getApiProgress() managerTypes.TransferProgress {
+	result := managerTypes.TransferProgress{
+		Phase:       t.Progress.Phase,
+		Nodes:       make(map[string]managerTypes.TransferNodeProgress),
+		FilesMerged: t.Progress.FilesMerged,
+	}
+
+	// transfers created before progress was tracked
+	if result.Phase == "" {
+		result.Phase = managerTypes.TransferPhasePreparing
+	}
+
+	for nodeId, mediaIds := range t.Inputs {
+		progress := t.Progress.Nodes[nodeId]
+
+		itemsPrepared := 0
+		if t.Outputs[nodeId] {
+			itemsPrepared = len(mediaIds)
+		}
+
+		result.Nodes[nodeId] = managerTypes.TransferNodeProgress{
+			ItemsPrepared:   itemsPrepared,
+			ItemsTotal:      len(mediaIds),
+			BytesDownloaded: progress.BytesDownloaded,
+			BytesTotal:      progress.BytesTotal,
+		}
+	}
+
+	return result
+}
+
+// returns the number of items of the transfer on each node
+func (t *Transfer) GetItemCounts() map[string]int {
+	result := make(map[string]int)
+
+	for nodeId, mediaIds := range t.Inputs {
+		result[nodeId] = len(mediaIds)
+	}
+
+	return result
+}
+
+// a transfer is active until its content is delivered or it fails
+func (t *Transfer) IsActive() bool {
+	return t.Status == StatusPending || t.Status == StatusInProgress || t.Status == StatusProcessComplete
+}
+
+// the archive of the transfer is on disk and still needed
+func (t *Transfer) HasArchive() bool {
+	return t.Status == StatusComplete || t.Status == StatusProcessComplete
+}
+
+func (t *Transfer) Cancel() {
+	t.Status = StatusCancelled
+	t.FailureReason = errTransferCancelled.Error()
+}
+
+// transfers created before modes were supported moved the media
+func (t *Transfer) getMode() string {
+	if t.Mode == "" {
+		return managerTypes.TransferModeMove
+	}
+
+	return t.Mode
+}
+
+// the media is deleted from the input nodes once a media host target received it
+func (t *Transfer) IsMove() bool {
+	return t.getMode() == managerTypes.TransferModeMove
+}
+
 func (t *Transfer) DidFail() bool {
 	return t.Status == StatusFailed
 }
@@ -76,6 +202,11 @@ func newTransferModel(targetId string, inputs map[string][]string, expiry *time.
 		Inputs:   inputs,
 		Status:   StatusPending,
 		Outputs:  outputs,
+		Layout:   app.GetApp().Config.Transfers.Layout,
+		// transfers to other nodes have always moved the media
+		Mode:      managerTypes.TransferModeMove,
+		Progress:  transferProgress{Phase: managerTypes.TransferPhasePreparing, Nodes: make(map[string]nodeProgress)},
+		CreatedAt: time.Now(),
 	}
 
 	if expiry != nil {
@@ -2,39 +2,46 @@ package transfer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"path"
 	"time"
 
 	"github.com/egfanboy/mediapire-common/exceptions"
 	"github.com/egfanboy/mediapire-common/messaging"
 	commonTypes "github.com/egfanboy/mediapire-common/types"
 	"github.com/egfanboy/mediapire-manager/internal/app"
+	"github.com/egfanboy/mediapire-manager/internal/node"
 	"github.com/egfanboy/mediapire-manager/internal/rabbitmq"
+	"github.com/egfanboy/mediapire-manager/internal/websocket"
 	"github.com/egfanboy/mediapire-manager/pkg/types"
+	"github.com/egfanboy/mediapire-manager/pkg/types/pagination"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type transfersApi interface {
-	Download(ctx context.Context, transferId string) ([]byte, error)
-	GetTransfer(ctx context.Context, transferId string) (commonTypes.Transfer, error)
+	GetTransfers(ctx context.Context, filter transferFilter, paginationParams *pagination.ApiPaginationParams) (interface{}, error)
+	// OpenArchive returns the archive of the transfer once it can be downloaded, the caller closes it
+	OpenArchive(ctx context.Context, transferId string) (*os.File, error)
+	GetTransfer(ctx context.Context, transferId string) (types.TransferItem, error)
 	CleanupTransfer(ctx context.Context, transferId string) error
 	CreateTransfer(ctx context.Context, body types.TransferCreateRequest) (commonTypes.Transfer, error)
+	CancelTransfer(ctx context.Context, transferId string) (commonTypes.Transfer, error)
 }
 
 type transfersService struct {
 }
 
-func (s *transfersService) Download(ctx context.Context, transferId string) ([]byte, error) {
+func (s *transfersService) OpenArchive(ctx context.Context, transferId string) (*os.File, error) {
 	log.Info().Msg("Download Transfer: start")
 
 	transferObjectId, err := primitive.ObjectIDFromHex(transferId)
 	if err != nil {
 		log.Err(err).Msgf("Failed to convert transferId %s to an ObjectId", transferId)
-		return nil, err
+		return nil, exceptions.NewBadRequestException(err)
 	}
 
 	transferRepo, err := NewTransferRepository(ctx)
@@ -46,6 +53,11 @@ func (s *transfersService) Download(ctx context.Context, transferId string) ([]b
 	transfer, err := transferRepo.GetById(ctx, transferObjectId)
 	if err != nil {
 		log.Err(err).Msgf("failed to get transfer with id %s from the database", transferId)
+
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, &exceptions.ApiException{Err: err, StatusCode: http.StatusNotFound}
+		}
+
 		return nil, err
 	}
 
@@ -75,13 +87,39 @@ func (s *transfersService) Download(ctx context.Context, transferId string) ([]b
 		}
 	}
 
-	fileContent, err := os.ReadFile(path.Join(app.GetApp().Config.DownloadPath, transferId+".zip"))
+	file, err := os.Open(archivePath(transferId))
 	if err != nil {
 		log.Err(err).Msgf("Failed to open item for transfer with id %s", transferId)
 		return nil, err
 	}
 
-	return fileContent, nil
+	return file, nil
+}
+
+func (s *transfersService) GetTransfers(
+	ctx context.Context,
+	filter transferFilter,
+	paginationParams *pagination.ApiPaginationParams) (result interface{}, err error) {
+	log.Info().Msg("Get Transfers: start")
+
+	transferRepo, err := NewTransferRepository(ctx)
+	if err != nil {
+		log.Err(err).Msg("failed to instantiate transfer repository")
+		return
+	}
+
+	transfers, err := transferRepo.GetAll(ctx, filter)
+	if err != nil {
+		log.Err(err).Msg("failed to get transfers from the database")
+		return
+	}
+
+	items := make([]types.TransferItem, len(transfers))
+	for i, t := range transfers {
+		items[i] = t.ToApiDetails()
+	}
+
+	return pagination.Paginate(items, paginationParams)
 }
 
 func (s *transfersService) getTransferModel(ctx context.Context, transferId string) (*Transfer, error) {
@@ -108,21 +146,21 @@ func (s *transfersService) getTransferModel(ctx context.Context, transferId stri
 	return transfer, nil
 }
 
-func (s *transfersService) GetTransfer(ctx context.Context, transferId string) (commonTypes.Transfer, error) {
+func (s *transfersService) GetTransfer(ctx context.Context, transferId string) (types.TransferItem, error) {
 	log.Info().Msgf("Get Transfer: start id %s", transferId)
 
 	transfer, err := s.getTransferModel(ctx, transferId)
 	if err != nil {
-		return commonTypes.Transfer{}, err
+		return types.TransferItem{}, err
 	}
 
-	return transfer.ToApiResponse(), nil
+	return transfer.ToApiDetails(), nil
 }
 
 func (t *transfersService) CleanupTransfer(ctx context.Context, transferId string) error {
 	log.Info().Msgf("Cleanup Transfer: start id %s", transferId)
 
-	err := os.RemoveAll(path.Join(app.GetApp().Config.DownloadPath, transferId+".zip"))
+	err := os.RemoveAll(archivePath(transferId))
 	if err != nil {
 		log.Err(err).Msgf("Failed to remove zip file for transfer %s", transferId)
 	}
@@ -150,18 +188,45 @@ func (s *transfersService) CreateTransfer(ctx context.Context, request types.Tra
 	}
 
 	targetId := app.GetApp().NodeId
-	if request.TargetId != nil {
+	if request.TargetId != nil && *request.TargetId != targetId {
 		targetId = *request.TargetId
+
+		err = s.validateTarget(ctx, targetId)
+		if err != nil {
+			return commonTypes.Transfer{}, err
+		}
 	}
 
 	t := NewTransferModel(targetId, inputs)
 
+	switch request.Mode {
+	case "":
+	case types.TransferModeCopy, types.TransferModeMove:
+		t.Mode = request.Mode
+	default:
+		return commonTypes.Transfer{}, exceptions.NewBadRequestException(
+			fmt.Errorf("unknown mode %q, expected %s or %s", request.Mode, types.TransferModeCopy, types.TransferModeMove),
+		)
+	}
+
+	if request.Layout != "" {
+		if !validLayouts[request.Layout] {
+			return commonTypes.Transfer{}, exceptions.NewBadRequestException(
+				fmt.Errorf("unknown layout %q, expected %s, %s or %s", request.Layout, types.TransferLayoutFlat, types.TransferLayoutNode, types.TransferLayoutTags),
+			)
+		}
+
+		t.Layout = request.Layout
+	}
+
 	err = transferRepo.Save(ctx, t)
 	if err != nil {
 		log.Err(err).Msg("failed to save new transfer")
 		return commonTypes.Transfer{}, err
 	}
 
+	broadcastTransfer(t)
+
 	msg := messaging.TransferMessage{
 		Id:       t.Id.Hex(),
 		TargetId: t.TargetId,
@@ -177,6 +242,89 @@ func (s *transfersService) CreateTransfer(ctx context.Context, request types.Tra
 
 }
 
+func (s *transfersService) CancelTransfer(ctx context.Context, transferId string) (commonTypes.Transfer, error) {
+	log.Info().Msgf("Cancel Transfer: start id %s", transferId)
+
+	transferObjectId, err := primitive.ObjectIDFromHex(transferId)
+	if err != nil {
+		log.Err(err).Msgf("Failed to convert transferId %s to an ObjectId", transferId)
+		return commonTypes.Transfer{}, exceptions.NewBadRequestException(err)
+	}
+
+	transferRepo, err := NewTransferRepository(ctx)
+	if err != nil {
+		log.Err(err).Msg("failed to instantiate transfer repository")
+		return commonTypes.Transfer{}, err
+	}
+
+	transfer, err := transferRepo.Update(ctx, transferObjectId, func(t *Transfer) error {
+		if !t.IsActive() {
+			return exceptions.NewBadRequestException(fmt.Errorf("cannot cancel transfer %s since it is %s", transferId, t.Status))
+		}
+
+		t.Cancel()
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msgf("failed to cancel transfer %s", transferId)
+		return commonTypes.Transfer{}, err
+	}
+
+	broadcastTransfer(transfer)
+
+	msg := transferCancelMessage{
+		TransferId: transfer.Id.Hex(),
+		TargetId:   transfer.TargetId,
+		Inputs:     transfer.Inputs,
+	}
+
+	// the transfer is cancelled even if the media hosts are not told, updates they send for it are ignored
+	err = rabbitmq.PublishMessage(ctx, topicTransferCancel, msg)
+	if err != nil {
+		log.Err(err).Msgf("failed to tell media hosts to abort transfer %s", transferId)
+	}
+
+	// remove content that may have been saved while the transfer was cancelled
+	s.CleanupTransfer(ctx, transferId)
+
+	return transfer.ToApiResponse(), nil
+}
+
+// clients follow the progress of transfers through their updates, it is sent once the transfer is saved
+func broadcastTransfer(t *Transfer) {
+	err := websocket.SendTransferUpdated(t.ToApiDetails())
+	if err != nil {
+		log.Err(err).Msgf("failed to broadcast update of transfer %s", t.Id.Hex())
+	}
+}
+
+// a media host target must be known and up to receive the content
+func (s *transfersService) validateTarget(ctx context.Context, targetId string) error {
+	nodeService, err := node.NewNodeService()
+	if err != nil {
+		return err
+	}
+
+	nodes, err := nodeService.GetAllNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range nodes {
+		if n.Id != targetId {
+			continue
+		}
+
+		if !n.IsUp {
+			return exceptions.NewBadRequestException(fmt.Errorf("target node %s is down", targetId))
+		}
+
+		return nil
+	}
+
+	return exceptions.NewBadRequestException(fmt.Errorf("target node %s does not exist", targetId))
+}
+
 func newTransfersService() transfersApi {
 	return &transfersService{}
 }
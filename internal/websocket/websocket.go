@@ -133,6 +133,25 @@ func SendMessage(msg []byte) {
 	hub.broadcast <- msg
 }
 
+type transferUpdatedEnvelope struct {
+	Type     string             `json:"type"`
+	Transfer types.TransferItem `json:"transfer"`
+}
+
+func SendTransferUpdated(transfer types.TransferItem) error {
+	payload, err := json.Marshal(transferUpdatedEnvelope{
+		Type:     "transfer.updated",
+		Transfer: transfer,
+	})
+	if err != nil {
+		return err
+	}
+
+	SendMessage(payload)
+
+	return nil
+}
+
 type playbackSessionUpdatedEnvelope struct {
 	Type  string                     `json:"type"`
 	State types.PlaybackSessionState `json:"state"`
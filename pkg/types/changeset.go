@@ -1,6 +1,26 @@
 package types
 
-import "time"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// art is stored by the hex encoded sha256 of its content
+var artBlobIdRegEx = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// fields of a media item that can be cleared by a change
+const (
+	FieldName    = "name"
+	FieldArtist  = "artist"
+	FieldAlbum   = "album"
+	FieldComment = "comment"
+	FieldGenre   = "genre"
+	FieldArt     = "art"
+)
 
 type MediaItemChange struct {
 	Name       string `json:"name"`
@@ -11,6 +31,8 @@ type MediaItemChange struct {
 	TrackIndex int    `json:"trackIndex"`
 	TrackOf    int    `json:"trackOf"`
 	Art        string `json:"art"`
+	// fields to remove from the item since an empty value leaves the field untouched, one of name, artist, album, comment, genre or art
+	Clear []string `json:"clear,omitempty"`
 }
 
 type Changeset struct {
@@ -21,6 +43,12 @@ type Changeset struct {
 type ChangesetCreateRequest struct {
 	Action  string      `json:"action"`
 	Changes []Changeset `json:"changes"`
+	// used by the tag_from_filename action, ie: %track% - %artist% - %title%
+	Template string `json:"template,omitempty"`
+	// creates the changeset as a draft that is only applied once committed
+	Draft bool `json:"draft,omitempty"`
+	// applies the changeset at the given time instead of right away
+	ApplyAt *time.Time `json:"applyAt,omitempty"`
 }
 
 type ChangesetItem struct {
@@ -29,5 +57,125 @@ type ChangesetItem struct {
 	Status        string     `json:"status"`
 	FailureReason string     `json:"failureReason" `
 	Expiry        *time.Time `json:"expiry" `
-	Type          string     `json:"type"`
+	// time at which a scheduled changeset is applied
+	ApplyAt *time.Time `json:"applyAt,omitempty"`
+	Type    string     `json:"type"`
+	// failure reason of every node that failed to apply the changeset, keyed by node id
+	NodeFailures map[string]string     `json:"nodeFailures"`
+	Items        []ChangesetItemResult `json:"items,omitempty"`
+	// number of items of the changeset on each node, keyed by node id
+	ItemCounts map[string]int `json:"itemCounts"`
+	// id of the changeset undone by this changeset
+	RevertOf string `json:"revertOf,omitempty"`
+	// id of the changeset whose failed items are retried by this changeset
+	RetryOf   string    `json:"retryOf,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type ChangesetItemResult struct {
+	MediaItemMapping
+	// pending, rewritten, applied, failed or cancelled
+	Status        string     `json:"status"`
+	FailureReason string     `json:"failureReason"`
+	UpdatedAt     *time.Time `json:"updatedAt"`
+}
+
+type MetadataFieldDiff struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type ChangesetPreviewItem struct {
+	MediaItemMapping
+	Diff     []MetadataFieldDiff `json:"diff"`
+	Warnings []string            `json:"warnings"`
+	// reason the item could not be previewed, it has no diff in that case
+	Error string `json:"error,omitempty"`
+}
+
+type TagSuggestionRequest struct {
+	Items []MediaItemMapping `json:"items"`
+}
+
+type TagSuggestion struct {
+	MediaItemMapping
+	Candidates []MediaItemChange `json:"candidates"`
+	// reason the lookup failed for the item, it has no candidates in that case
+	Error string `json:"error,omitempty"`
+}
+
+type TagSuggestionResponse struct {
+	Suggestions []TagSuggestion `json:"suggestions"`
+	// applies the best candidate of every item, can be sent as is to create a changeset
+	Changeset ChangesetCreateRequest `json:"changeset"`
+}
+
+// ArtInput is the art of a JSON changeset request. It is either a string holding the id of uploaded art or base64 encoded content,
+// or an object referencing the art of another media item
+type ArtInput struct {
+	BlobId  string
+	Content []byte
+	Media   *MediaItemMapping
+}
+
+func (a *ArtInput) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		if artBlobIdRegEx.MatchString(value) {
+			a.BlobId = value
+			return nil
+		}
+
+		content, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("art must be an uploaded art id or base64 encoded content: %w", err)
+		}
+
+		a.Content = content
+		return nil
+	}
+
+	var mapping MediaItemMapping
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return err
+	}
+
+	if mapping.NodeId == "" || mapping.MediaId == "" {
+		return errors.New("art referencing a media item requires both nodeId and mediaId")
+	}
+
+	a.Media = &mapping
+	return nil
+}
+
+type JsonMediaItemChange struct {
+	MediaItemChange
+	Art *ArtInput `json:"art,omitempty"`
+}
+
+type JsonChangeset struct {
+	MediaItemMapping
+	Change JsonMediaItemChange `json:"change"`
+}
+
+// ChangesetJsonCreateRequest is the JSON variant of ChangesetCreateRequest where art does not need to be uploaded as a file
+type ChangesetJsonCreateRequest struct {
+	Action   string          `json:"action"`
+	Changes  []JsonChangeset `json:"changes"`
+	Template string          `json:"template,omitempty"`
+	Draft    bool            `json:"draft,omitempty"`
+	ApplyAt  *time.Time      `json:"applyAt,omitempty"`
+}
+
+// ChangesetPatchRequest edits the items of a draft changeset
+type ChangesetPatchRequest struct {
+	// adds the items to the draft or replaces their change if they are already part of it
+	Upsert []JsonChangeset    `json:"upsert"`
+	Remove []MediaItemMapping `json:"remove"`
+}
+
+type ArtUploadResponse struct {
+	Id string `json:"id"`
 }
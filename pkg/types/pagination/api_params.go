@@ -2,7 +2,9 @@ package pagination
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/egfanboy/mediapire-common/exceptions"
 	"github.com/egfanboy/mediapire-common/router"
@@ -66,3 +68,18 @@ func NewApiPaginationParams(p router.RouteParams) (result ApiPaginationParams, e
 
 	return
 }
+
+// ParseTimeParam parses the RFC3339 date of the query param, nil when the param is not set
+func ParseTimeParam(p router.RouteParams, name string) (*time.Time, error) {
+	value, ok := p.Params[name]
+	if !ok {
+		return nil, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, exceptions.NewBadRequestException(fmt.Errorf("invalid %s query param, expected an RFC3339 date: %w", name, err))
+	}
+
+	return &parsed, nil
+}
@@ -61,3 +61,17 @@ func NewPaginatedResponse[T any](data []T, pagination ApiPaginationParams) (resu
 		Pagination: p,
 	}, nil
 }
+
+// Paginate returns every item when no pagination is requested, otherwise the requested page.
+// A first page without any item is empty rather than missing
+func Paginate[T any](data []T, pagination *ApiPaginationParams) (interface{}, error) {
+	if pagination == nil {
+		return data, nil
+	}
+
+	if len(data) == 0 && pagination.Page == 1 {
+		return PaginatedResponse[T]{Results: data, Pagination: Pagination{CurrentPage: 1}}, nil
+	}
+
+	return NewPaginatedResponse(data, *pagination)
+}
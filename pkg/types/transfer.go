@@ -1,6 +1,87 @@
 package types
 
+import "time"
+
+// layouts of the files in the archive of a transfer
+const (
+	// every file at the root of the archive, files with the same name are numbered
+	TransferLayoutFlat = "flat"
+	// files in a folder per node
+	TransferLayoutNode = "node"
+	// files in Artist/Album folders based on their tags
+	TransferLayoutTags = "tags"
+)
+
+// what happens to the media on the input nodes once a transfer to another node is complete
+const (
+	// the media is kept on the input nodes
+	TransferModeCopy = "copy"
+	// the media is deleted from the input nodes
+	TransferModeMove = "move"
+)
+
 type TransferCreateRequest struct {
 	TargetId *string            `json:"targetId"`
 	Inputs   []MediaItemMapping `json:"inputs"`
+	// one of flat, node or tags. Defaults to the layout of the manager config
+	Layout string `json:"layout,omitempty"`
+	// copy or move, only used when the target is a media host. Defaults to move
+	Mode string `json:"mode,omitempty"`
+}
+
+type TransferItem struct {
+	Id            string    `json:"id"`
+	TargetId      string    `json:"targetId"`
+	Status        string    `json:"status"`
+	FailureReason string    `json:"failureReason"`
+	Expiry        time.Time `json:"expiry"`
+	// ids of the media of the transfer, keyed by node id
+	Inputs map[string][]string `json:"inputs"`
+	// number of items of the transfer on each node, keyed by node id
+	ItemCounts map[string]int `json:"itemCounts"`
+	// layout of the files in the archive, flat, node or tags
+	Layout string `json:"layout"`
+	// copy or move
+	Mode      string           `json:"mode"`
+	Progress  TransferProgress `json:"progress"`
+	CreatedAt time.Time        `json:"createdAt"`
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
+
+// TransferManifest describes every file of the archive of a transfer, it is included in the archive as manifest.json
+type TransferManifest struct {
+	TransferId string                 `json:"transferId"`
+	Layout     string                 `json:"layout"`
+	Files      []TransferManifestFile `json:"files"`
+}
+
+type TransferManifestFile struct {
+	MediaItemMapping
+	// path of the file in the archive
+	Path string `json:"path"`
+}
+
+// phases of a transfer, the nodes prepare their content which is then downloaded and merged by the manager
+const (
+	TransferPhasePreparing   = "preparing"
+	TransferPhaseDownloading = "downloading"
+	TransferPhaseMerging     = "merging"
+	TransferPhaseDone        = "done"
+)
+
+type TransferProgress struct {
+	Phase string `json:"phase"`
+	// progress of every node of the transfer, keyed by node id
+	Nodes map[string]TransferNodeProgress `json:"nodes"`
+	// number of files copied into the archive of the transfer
+	FilesMerged int `json:"filesMerged"`
+}
+
+type TransferNodeProgress struct {
+	// media hosts report a node once all its items are prepared, it goes from 0 to itemsTotal at once
+	ItemsPrepared   int   `json:"itemsPrepared"`
+	ItemsTotal      int   `json:"itemsTotal"`
+	BytesDownloaded int64 `json:"bytesDownloaded"`
+	// size of the archive of the node, 0 when the node does not send it
+	BytesTotal int64 `json:"bytesTotal"`
 }